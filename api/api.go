@@ -3,11 +3,12 @@ package api
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
-	"time"
 	"log"
 	"connect4/db"
 	"connect4/games"
@@ -63,7 +64,20 @@ func GetGames(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	respondWithJSON(w, http.StatusOK, games)
+	// Each game is marshalled under its lock, a bot may be moving in it
+	list := make([]json.RawMessage, 0, len(games))
+	for _, game := range games {
+		game.Lock()
+		data, err := json.Marshal(game)
+		game.Unlock()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving games")
+			return
+		}
+		list = append(list, data)
+	}
+	
+	respondWithJSON(w, http.StatusOK, list)
 }
 // CreatePlayer creates a new player
 func CreatePlayer(w http.ResponseWriter, r *http.Request) {
@@ -128,15 +142,31 @@ func GetLeaderboard(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, leaderboard)
 }
 
+// GetBotStrategies lists the bot strategies a game can be created with, as "bot:<name>"
+func GetBotStrategies(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, games.StrategyNames())
+}
+
+// GetProfiles lists the evaluation profiles a bot game can be created with
+func GetProfiles(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, games.Profiles())
+}
+
 // Game handlers
 // CreateGame creates a new game
 func CreateGame(w http.ResponseWriter, r *http.Request) {
 
 	log.Println("CreateGame")
 	var requestData struct {
-		GameType  games.GameType `json:"gameType"`
-		Player1ID string        `json:"player1Id"`
-		Player2ID string        `json:"player2Id,omitempty"`
+		GameType    games.GameType     `json:"gameType"`
+		Player1ID   string             `json:"player1Id"`
+		Player2ID   string             `json:"player2Id,omitempty"`
+		Rules       games.Rules        `json:"rules"`
+		Variant     games.Variant      `json:"variant,omitempty"`     // Shorthand for rules.variant
+		Notation    string             `json:"notation,omitempty"`    // Move sequence or board snapshot to start from
+		TimeControl *games.TimeControl `json:"timeControl,omitempty"` // Untimed if not given
+		BotLevel    games.BotLevel     `json:"botLevel,omitempty"`    // Difficulty of the bot, the default level if not given
+		BotProfile  string             `json:"botProfile,omitempty"`  // Evaluation profile of the bot, see /api/profiles
 	}
 	
 	decoder := json.NewDecoder(r.Body)
@@ -155,11 +185,35 @@ func CreateGame(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Println(requestData.GameType)
 
+	// Fill in the classic board for anything not given and check the rest
+	if requestData.Variant != "" {
+		requestData.Rules.Variant = requestData.Variant
+	}
+	rules := requestData.Rules.WithDefaults()
+	if err := rules.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid rules: "+err.Error())
+		return
+	}
+	if requestData.TimeControl != nil {
+		if err := requestData.TimeControl.Validate(); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid time control: "+err.Error())
+			return
+		}
+	}
+
 	// Set default player IDs for single player mode
 	if requestData.GameType == games.SinglePlayer && requestData.Player2ID == "" {
 		requestData.Player2ID = "bot"
 	}
 	
+	// A bot player must name a strategy the server knows
+	for _, playerID := range []string{requestData.Player1ID, requestData.Player2ID} {
+		if err := games.ValidatePlayerID(playerID); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid player: "+err.Error())
+			return
+		}
+	}
+	
 	// Make sure player IDs are provided for multiplayer
 	if requestData.GameType == games.OnlineMultiplayer && 
 	  (requestData.Player1ID == "") {
@@ -168,16 +222,50 @@ func CreateGame(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Create the game
-	newGame := games.NewGame(requestData.GameType, requestData.Player1ID, requestData.Player2ID)
+	newGame := games.NewGame(requestData.GameType, requestData.Player1ID, requestData.Player2ID, rules)
+	if requestData.Notation != "" {
+		var err error
+		newGame, err = games.NewGameFromNotation(requestData.GameType, requestData.Player1ID, requestData.Player2ID, rules, requestData.Notation)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid notation: "+err.Error())
+			return
+		}
+	}
+	
+	if requestData.BotLevel != "" {
+		if err := newGame.SetBotLevel(requestData.BotLevel); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid bot level: "+err.Error())
+			return
+		}
+	}
+	if requestData.BotProfile != "" {
+		if err := newGame.SetBotProfile(requestData.BotProfile); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid bot profile: "+err.Error())
+			return
+		}
+	}
 	
 	// Start the game immediately
 	
-	if requestData.GameType == games.OnlineMultiplayer && requestData.Player2ID == "" {
-		// Set status to waiting if no Player2 yet
-		newGame.Status = games.StatusWaiting
-	} else {
-		// Otherwise, start the game immediately
-		newGame.Status = games.StatusActive
+	newGame.SetTimeControl(requestData.TimeControl)
+	
+	// A game played to the end by its notation stays finished
+	if newGame.Status != games.StatusFinished {
+		if requestData.GameType == games.OnlineMultiplayer && requestData.Player2ID == "" {
+			// Set status to waiting if no Player2 yet
+			newGame.Status = games.StatusWaiting
+		} else {
+			// Otherwise, start the game immediately
+			newGame.Start()
+		}
+	}
+	
+	// A bot whose turn it is at the start moves right away
+	if newGame.Status == games.StatusActive && newGame.BotFor(newGame.CurrentTurn) != nil {
+		if err := newGame.PlayBotMove(); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Bot move error: "+err.Error())
+			return
+		}
 	}
 	
 	
@@ -186,6 +274,7 @@ func CreateGame(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, "Error creating game")
 		return
 	}
+	db.ScheduleClock(newGame)
 	log.Printf("Game type : %v", games.SinglePlayer)
 	log.Printf("Sending response to client : %v", newGame)
 	respondWithJSON(w, http.StatusCreated, newGame)
@@ -201,10 +290,78 @@ func GetGame(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusNotFound, "Game not found")
 		return
 	}
+	game.Lock()
+	defer game.Unlock()
+	
+	// ?format=notation returns the game as text instead
+	if r.URL.Query().Get("format") == "notation" {
+		notation, err := game.Notation()
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		respondWithJSON(w, http.StatusOK, notation)
+		return
+	}
+	
+	// ?debug=1 adds how the bots found their moves
+	if r.URL.Query().Get("debug") == "1" {
+		respondWithJSON(w, http.StatusOK, struct {
+			*games.Game
+			Debug gameDebug `json:"debug"`
+		}{game, gameDebug{BotMoves: game.BotSearches()}})
+		return
+	}
 	
 	respondWithJSON(w, http.StatusOK, game)
 }
 
+// gameDebug is the debug section of a game, see GetGame
+type gameDebug struct {
+	BotMoves []games.MoveSearch `json:"botMoves"`
+}
+
+// GetGameMoves returns the move history of a game in the order the moves were played
+func GetGameMoves(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gameID := vars["id"]
+	
+	game, err := db.GetGame(gameID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Game not found")
+		return
+	}
+	game.Lock()
+	defer game.Unlock()
+	
+	respondWithJSON(w, http.StatusOK, game.Moves)
+}
+
+// GetGameAnalysis runs the bot's search on the current position for one of
+// the game's players, given by the playerId query parameter
+func GetGameAnalysis(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gameID := vars["id"]
+	
+	game, err := db.GetGame(gameID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Game not found")
+		return
+	}
+	
+	analysis, err := db.AnalyzeGame(game, r.URL.Query().Get("playerId"))
+	if err == db.ErrHintBudget {
+		respondWithError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	
+	respondWithJSON(w, http.StatusOK, analysis)
+}
+
 // NOTE : we have to save the players in the game, not their id , or we could save the bot for each game
 // MakeMove makes a move in a game
 func MakeMove(w http.ResponseWriter, r *http.Request) {
@@ -226,9 +383,12 @@ func MakeMove(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusNotFound, "Game not found")
 		return
 	}
+	currentGame.Lock()
+	defer currentGame.Unlock()
+	defer db.ScheduleClock(currentGame)
 	
 	// Make the move
-	if err := currentGame.MakeMove(move.PlayerID, move.Column); err != nil {
+	if err := currentGame.ApplyMove(move); err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
@@ -240,24 +400,13 @@ func MakeMove(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// If game is against bot and it's bot's turn, make the bot move
-	if currentGame.Status == games.StatusActive && 
-	   ((currentGame.Player1ID == "bot" && currentGame.CurrentTurn == games.RedToken) || 
-		(currentGame.Player2ID == "bot" && currentGame.CurrentTurn == games.YellowToken)) {
-		
-		// Get bot move
-		botColumn := currentGame.Bot.GetNextMove(currentGame)
-		botPlayerID := currentGame.Player1ID
-		if currentGame.Player1ID != "bot" {
-			botPlayerID = currentGame.Player2ID
+	if currentGame.Status == games.StatusActive && currentGame.BotFor(currentGame.CurrentTurn) != nil {
+		// The player's move is made and saved either way, so a failed bot
+		// move is no error for the request
+		if err := currentGame.PlayBotMove(); err != nil {
+			log.Printf("Bot move error in game %s: %v", gameID, err)
 		}
 		
-		// Apply bot move
-		if err := currentGame.MakeMove(botPlayerID, botColumn); err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Bot move error: "+err.Error())
-			return
-		}
-		
-		
 		// Save the game state
 		if err := db.SaveGame(currentGame); err != nil {
 			respondWithError(w, http.StatusInternalServerError, "Error saving game after bot move")
@@ -265,6 +414,11 @@ func MakeMove(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	
+	// Count the result if the move ended the game, like a move over the WebSocket
+	if currentGame.Status == games.StatusFinished {
+		db.UpdatePlayerStats(currentGame)
+	}
+	
 	// Broadcast game update to WebSocket clients
 	//db.BroadcastGameState(gameID, currentGame)
 	
@@ -282,69 +436,290 @@ func ResetGame(w http.ResponseWriter, r *http.Request) {
         respondWithError(w, http.StatusNotFound, "Game not found")
         return
     }
+	currentGame.Lock()
+	defer currentGame.Unlock()
+	defer db.ScheduleClock(currentGame)
 	// Give first turn to the winner, or alternate if it was a draw
+	startingToken := games.RedToken
 	if currentGame.WinnerID != "" {
 		if currentGame.WinnerID == currentGame.Player1ID {
-			currentGame.CurrentTurn = games.RedToken
+			startingToken = games.RedToken
 		} else {
 			
-			currentGame.CurrentTurn = games.YellowToken
+			startingToken = games.YellowToken
 		}
-	} else {
-		// If it was a draw, alternate starting player
-		currentGame.CurrentTurn = games.RedToken
-		
 	}
     // Reset the game state
-    currentGame.Board = games.NewBoard()
-     
-    currentGame.Status = games.StatusActive
-    currentGame.WinnerID = ""
-    currentGame.LastMoveTime = time.Now()
-
-	if currentGame.Player1ID == "bot" || currentGame.Player2ID == "bot" {
-        
-        if currentGame.Player1ID == "bot" {
-            currentGame.Bot.PlayerID = currentGame.Player1ID
-            currentGame.Bot.PlayerToken = games.RedToken
-            currentGame.Bot.OpponentToken = games.YellowToken
-            
-            
-        } else {
-            currentGame.Bot.PlayerID = currentGame.Player2ID
-            currentGame.Bot.PlayerToken = games.YellowToken
-            currentGame.Bot.OpponentToken = games.RedToken
-        }
+    currentGame.Reset(startingToken)
 
-		if currentGame.CurrentTurn == games.YellowToken {
-            botColumn := currentGame.Bot.GetNextMove(currentGame)
-			
-            log.Printf("Bot move: %d", botColumn)
-            // Apply bot move
-            if err := currentGame.MakeMove(currentGame.Player2ID, botColumn); err != nil {
-                respondWithError(w, http.StatusInternalServerError, "Bot move error: "+err.Error())
-                return
-            }
-			
-			currentGame.CurrentTurn = games.RedToken
+	// The bot opens the new round if it starts
+	if currentGame.BotFor(currentGame.CurrentTurn) != nil {
+		if err := currentGame.PlayBotMove(); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Bot move error: "+err.Error())
+			return
 		}
-		if err := db.SaveGame(currentGame); err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Error saving reset game")
+	}
+	if err := db.SaveGame(currentGame); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error saving reset game")
+		return
+	}
+	
+	// Broadcast game update to WebSocket clients
+	//db.BroadcastGameState(gameID, currentGame)
+	
+	// Return the reset game
+	respondWithJSON(w, http.StatusOK, currentGame)
+}
+
+// UndoMove takes back the last move of a single player or local game.
+// Against the bot the bot's reply is taken back too. Online games need the
+// opponent's consent, so they use the undoRequest/undoConfirm messages instead.
+func UndoMove(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gameID := vars["id"]
+	
+	currentGame, err := db.GetGame(gameID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Game not found")
+		return
+	}
+	
+	if currentGame.Type == games.OnlineMultiplayer {
+		respondWithError(w, http.StatusBadRequest, "Online games need the opponent to accept a takeback over the game connection")
+		return
+	}
+	currentGame.Lock()
+	defer currentGame.Unlock()
+	defer db.ScheduleClock(currentGame)
+	
+	if err := currentGame.TakeBack(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	
+	if err := db.SaveGame(currentGame); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error saving game")
+		return
+	}
+	
+	respondWithJSON(w, http.StatusOK, currentGame)
+}
+
+// Resign ends the game as a loss for the requesting player
+func Resign(w http.ResponseWriter, r *http.Request) {
+	gameAction(w, r, (*games.Game).Resign)
+}
+
+// OfferDraw offers the opponent a draw, it lapses after the player's next move
+func OfferDraw(w http.ResponseWriter, r *http.Request) {
+	gameAction(w, r, (*games.Game).OfferDraw)
+}
+
+// AcceptDraw ends the game as a draw if the opponent offered one
+func AcceptDraw(w http.ResponseWriter, r *http.Request) {
+	gameAction(w, r, (*games.Game).AcceptDraw)
+}
+
+// DeclineDraw rejects the opponent's draw offer
+func DeclineDraw(w http.ResponseWriter, r *http.Request) {
+	gameAction(w, r, (*games.Game).DeclineDraw)
+}
+
+// gameAction runs a player's action on a game, then saves it and tells the
+// game's connections, the same way the game WebSocket does
+func gameAction(w http.ResponseWriter, r *http.Request, action func(*games.Game, string) error) {
+	vars := mux.Vars(r)
+	gameID := vars["id"]
+	
+	var request struct {
+		PlayerID string `json:"playerId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	
+	currentGame, err := db.GetGame(gameID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Game not found")
+		return
+	}
+	currentGame.Lock()
+	defer currentGame.Unlock()
+	
+	if err := action(currentGame, request.PlayerID); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	db.SaveAndBroadcast(currentGame)
+	
+	respondWithJSON(w, http.StatusOK, currentGame)
+}
+
+// Exhibition handlers
+// exhibitionSeat is one bot of an exhibition
+type exhibitionSeat struct {
+	Strategy string         `json:"strategy"`        // Name from /api/bots, the default strategy if not given
+	Level    games.BotLevel `json:"level,omitempty"`   // The default level if not given
+	Profile  string         `json:"profile,omitempty"` // Evaluation profile, the default one if not given
+}
+
+// playerID returns the bot player id of the seat
+func (s exhibitionSeat) playerID() string {
+	if s.Strategy == "" {
+		return "bot"
+	}
+	return "bot:" + s.Strategy
+}
+
+// CreateExhibition creates a game between two bots that the server plays
+// out, watchers follow it on the game's WebSocket
+func CreateExhibition(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		Red     exhibitionSeat `json:"red"`
+		Yellow  exhibitionSeat `json:"yellow"`
+		DelayMs int64          `json:"delayMs"`           // Pause between moves
+		Rules   games.Rules    `json:"rules"`
+		Variant games.Variant  `json:"variant,omitempty"` // Shorthand for rules.variant
+		Start   bool           `json:"start"`             // Start playing right away instead of paused
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	
+	if requestData.DelayMs < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid delay")
+		return
+	}
+	if requestData.Variant != "" {
+		requestData.Rules.Variant = requestData.Variant
+	}
+	rules := requestData.Rules.WithDefaults()
+	if err := rules.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid rules: "+err.Error())
+		return
+	}
+	
+	player1ID, player2ID := requestData.Red.playerID(), requestData.Yellow.playerID()
+	for _, playerID := range []string{player1ID, player2ID} {
+		if err := games.ValidatePlayerID(playerID); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid player: "+err.Error())
 			return
 		}
-		
-		// Broadcast game update to WebSocket clients
-		//db.BroadcastGameState(gameID, currentGame)
-		
-		// Return the reset game
-		respondWithJSON(w, http.StatusOK, currentGame)
-    }
+	}
+	
+	newGame := games.NewGame(games.Exhibition, player1ID, player2ID, rules)
+	seats := map[int]exhibitionSeat{games.RedToken: requestData.Red, games.YellowToken: requestData.Yellow}
+	for token, seat := range seats {
+		if seat.Level != "" {
+			if err := newGame.SetSeatBotLevel(token, seat.Level); err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid bot level: "+err.Error())
+				return
+			}
+		}
+		if seat.Profile != "" {
+			if err := newGame.SetSeatBotProfile(token, seat.Profile); err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid bot profile: "+err.Error())
+				return
+			}
+		}
+	}
+	newGame.Start()
+	
+	// A started exhibition's first move waits until the game is sent back
+	newGame.Lock()
+	defer newGame.Unlock()
+	if err := db.CreateGame(newGame); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating game")
+		return
+	}
+	delay := time.Duration(requestData.DelayMs) * time.Millisecond
+	exhibition, err := db.CreateExhibition(newGame, delay)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if requestData.Start {
+		exhibition, _ = db.StartExhibition(newGame.ID, delay)
+	}
+	
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"game":       newGame,
+		"exhibition": exhibition,
+	})
+}
+
+// GetExhibition returns whether a game's bots are playing
+func GetExhibition(w http.ResponseWriter, r *http.Request) {
+	exhibition, err := db.GetExhibition(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, exhibition)
+}
+
+// StartExhibition lets the bots of an exhibition play, an optional delayMs
+// in the body changes the pause between moves
+func StartExhibition(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		DelayMs *int64 `json:"delayMs,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	
+	delay := time.Duration(-1)
+	if request.DelayMs != nil {
+		if *request.DelayMs < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid delay")
+			return
+		}
+		delay = time.Duration(*request.DelayMs) * time.Millisecond
+	}
+	exhibitionAction(w, r, func(gameID string) (db.Exhibition, error) {
+		return db.StartExhibition(gameID, delay)
+	})
+}
+
+// PauseExhibition stops the bots of an exhibition after the current move
+func PauseExhibition(w http.ResponseWriter, r *http.Request) {
+	exhibitionAction(w, r, db.PauseExhibition)
+}
+
+// StopExhibition ends an exhibition, its game is abandoned
+func StopExhibition(w http.ResponseWriter, r *http.Request) {
+	exhibitionAction(w, r, db.StopExhibition)
+}
+
+// exhibitionAction runs a start, pause or stop on the exhibition of the game in the url
+func exhibitionAction(w http.ResponseWriter, r *http.Request, action func(string) (db.Exhibition, error)) {
+	gameID := mux.Vars(r)["id"]
+	if _, err := db.GetExhibition(gameID); err != nil {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	
+	exhibition, err := action(gameID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, exhibition)
 }
 
 func MatchMaking(w http.ResponseWriter, r *http.Request) {
     // Parse player ID from request
     var request struct {
-        PlayerID string `json:"playerId"`
+        PlayerID    string             `json:"playerId"`
+        Rules       games.Rules        `json:"rules"`
+        Variant     games.Variant      `json:"variant,omitempty"` // Shorthand for rules.variant
+        TimeControl *games.TimeControl `json:"timeControl,omitempty"` // Untimed if not given
     }
     
     if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -352,6 +727,22 @@ func MatchMaking(w http.ResponseWriter, r *http.Request) {
         return
     }
     
+    // Players are only matched with games using the same rules and variant
+    if request.Variant != "" {
+        request.Rules.Variant = request.Variant
+    }
+    rules := request.Rules.WithDefaults()
+    if err := rules.Validate(); err != nil {
+        respondWithError(w, http.StatusBadRequest, "Invalid rules: "+err.Error())
+        return
+    }
+    if request.TimeControl != nil {
+        if err := request.TimeControl.Validate(); err != nil {
+            respondWithError(w, http.StatusBadRequest, "Invalid time control: "+err.Error())
+            return
+        }
+    }
+    
     
     gamelist, err := db.ListGame()
     if err != nil {
@@ -364,11 +755,16 @@ func MatchMaking(w http.ResponseWriter, r *http.Request) {
         if game.Type == games.OnlineMultiplayer && 
            game.Status == games.StatusWaiting && 
            game.Player1ID != request.PlayerID && 
-           game.Player2ID == "" {
+           game.Player2ID == "" &&
+           game.Rules == rules &&
+           db.SameTimeControl(game, request.TimeControl) {
             
             // Found a game to join
+            game.Lock()
             game.Player2ID = request.PlayerID
-            game.Status = games.StatusActive
+            game.Start()
+            db.ScheduleClock(game)
+            game.Unlock()
             
             if err := db.SaveGame(game); err != nil {
                 respondWithError(w, http.StatusInternalServerError, "Error updating game")
@@ -389,7 +785,8 @@ func MatchMaking(w http.ResponseWriter, r *http.Request) {
     }
     
     // No waiting games found, create a new one
-    newGame := games.NewGame(games.OnlineMultiplayer, request.PlayerID, "")
+    newGame := games.NewGame(games.OnlineMultiplayer, request.PlayerID, "", rules)
+    newGame.SetTimeControl(request.TimeControl)
     if err := db.SaveGame(newGame); err != nil {
         respondWithError(w, http.StatusInternalServerError, "Error creating game")
         return
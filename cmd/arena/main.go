@@ -0,0 +1,513 @@
+// arena measures the strength of bot configurations by playing round-robin
+// matches between them, e.g.
+//
+//	go run ./cmd/arena -bots "minimax:hard:time=0,minimax:medium:time=0,mcts:medium" -games 200
+//
+// An entrant is a strategy name, see games.StrategyNames, followed by an
+// optional level and options separated by colons. The minimax bot takes
+// depth, endgame, time, workers, noise, mistakes and profile, the solver
+// takes the same for the minimax bot it falls back to, e.g.
+// "solver:time=0:depth=12". The MCTS bot takes iterations, time, exploration
+// and heuristic. time is in milliseconds and 0 turns the time limit off.
+// profile is the name of a profile from the -profiles directory or the path
+// of a profile file.
+//
+// Every pair of entrants plays balanced openings, each opening twice with
+// the colors swapped. Runs with the same seed play the same games as long as
+// no entrant is limited by time or searches with more than one worker, which
+// is why minimax and solver entrants default to workers=1.
+package main
+
+import (
+	"connect4/games"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxGamePlies ends PopOut games that go on and on as a draw
+const maxGamePlies = 400
+
+// entrant is one bot configuration of the tournament
+type entrant struct {
+	spec     string
+	strategy string
+	level    games.BotLevel
+	options  map[string]string
+}
+
+// result counts the games of one entrant against another, from its side
+type result struct {
+	wins, draws, losses int
+}
+
+func main() {
+	botsFlag := flag.String("bots", "minimax:hard:time=0,minimax:medium:time=0", "Comma separated entrants, see the package comment")
+	gamesFlag := flag.Int("games", 100, "Most games per pair of entrants, rounded up to an even number")
+	seed := flag.Int64("seed", 1, "Seed for the openings and the bots")
+	openingPlies := flag.Int("opening-plies", 2, "Moves of the openings")
+	balance := flag.Int("balance", 200, "Only use openings the bot scores within this much of even")
+	sprt := flag.Bool("sprt", true, "Stop a pair early once the SPRT accepts either hypothesis")
+	elo0 := flag.Float64("elo0", 0, "SPRT Elo difference of the null hypothesis")
+	elo1 := flag.Float64("elo1", 30, "SPRT Elo difference of the alternative hypothesis")
+	alpha := flag.Float64("alpha", 0.05, "SPRT false positive rate")
+	beta := flag.Float64("beta", 0.05, "SPRT false negative rate")
+	minGames := flag.Int("min-games", 20, "Games a pair plays before the SPRT may stop it")
+	width := flag.Int("width", games.BoardWidth, "Board width")
+	height := flag.Int("height", games.BoardHeight, "Board height")
+	connect := flag.Int("connect", games.ConnectLength, "Discs in a line needed to win")
+	variant := flag.String("variant", string(games.VariantStandard), "Rules variant")
+	profileDir := flag.String("profiles", "profiles", "Directory of evaluation profiles for the profile option")
+	flag.Parse()
+
+	if _, err := games.LoadProfiles(*profileDir); err != nil {
+		log.Fatalf("Failed to load evaluation profiles: %v", err)
+	}
+
+	rules := games.Rules{Width: *width, Height: *height, Connect: *connect, Variant: games.Variant(*variant)}
+	if err := rules.Validate(); err != nil {
+		log.Fatalf("Invalid rules: %v", err)
+	}
+
+	entrants := []entrant{}
+	for _, spec := range strings.Split(*botsFlag, ",") {
+		e, err := parseEntrant(strings.TrimSpace(spec))
+		if err != nil {
+			log.Fatalf("Invalid entrant %q: %v", spec, err)
+		}
+		entrants = append(entrants, e)
+	}
+	if len(entrants) < 2 {
+		log.Fatal("The arena needs at least two entrants")
+	}
+
+	openings := balancedOpenings(rules, *openingPlies, *balance, *seed)
+	if len(openings) == 0 {
+		log.Fatal("No opening is balanced enough, raise -balance")
+	}
+	log.Printf("%d entrants, %d openings", len(entrants), len(openings))
+
+	lower := math.Log(*beta / (1 - *alpha))
+	upper := math.Log((1 - *beta) / *alpha)
+	results := make([][]result, len(entrants))
+	for i := range results {
+		results[i] = make([]result, len(entrants))
+	}
+
+	pair := 0
+	for i := range entrants {
+		for j := i + 1; j < len(entrants); j++ {
+			r := &results[i][j]
+			verdict := ""
+			for n := 0; n < *gamesFlag; n += 2 {
+				// The same opening with each entrant playing red once
+				opening := openings[(n/2)%len(openings)]
+				for swap := 0; swap < 2; swap++ {
+					gameSeed := *seed + int64(pair)*1000003 + int64(n+swap)
+					score, err := playGame(rules, opening, entrants[i], entrants[j], swap == 1, gameSeed)
+					if err != nil {
+						log.Fatalf("%s vs %s: %v", entrants[i].spec, entrants[j].spec, err)
+					}
+					switch score {
+					case 1:
+						r.wins++
+					case 0.5:
+						r.draws++
+					default:
+						r.losses++
+					}
+				}
+
+				if *sprt && r.games() >= *minGames {
+					llr := r.llr(*elo0, *elo1)
+					if llr >= upper {
+						verdict = fmt.Sprintf("SPRT: H1 (%+.0f Elo) accepted", *elo1)
+						break
+					}
+					if llr <= lower {
+						verdict = fmt.Sprintf("SPRT: H0 (%+.0f Elo) accepted", *elo0)
+						break
+					}
+				}
+			}
+			results[j][i] = result{wins: r.losses, draws: r.draws, losses: r.wins}
+			pair++
+
+			elo, margin := r.elo()
+			fmt.Printf("%s vs %s: +%d =%d -%d, %s Elo", entrants[i].spec, entrants[j].spec, r.wins, r.draws, r.losses, formatElo(elo, margin))
+			if *sprt {
+				fmt.Printf(", LLR %.2f [%.2f, %.2f]", r.llr(*elo0, *elo1), lower, upper)
+			}
+			if verdict != "" {
+				fmt.Printf(", %s", verdict)
+			}
+			fmt.Println()
+		}
+	}
+
+	printTable(entrants, results)
+}
+
+// parseEntrant parses "strategy[:level][:option=value...]" and checks it by creating the bot once
+func parseEntrant(spec string) (entrant, error) {
+	parts := strings.Split(spec, ":")
+	e := entrant{spec: spec, strategy: parts[0], options: map[string]string{}}
+	for _, part := range parts[1:] {
+		if key, value, ok := strings.Cut(part, "="); ok {
+			e.options[key] = value
+		} else {
+			e.level = games.BotLevel(part)
+		}
+	}
+	_, err := e.newBot(0)
+	return e, err
+}
+
+// newBot creates a fresh bot for one game, so no game learns from the one before
+func (e entrant) newBot(seed int64) (games.Strategy, error) {
+	bot, err := games.NewStrategy(e.strategy)
+	if err != nil {
+		return nil, err
+	}
+	if e.level != "" {
+		leveled, ok := bot.(games.LeveledStrategy)
+		if !ok {
+			return nil, errors.New(e.strategy + " has no levels")
+		}
+		if err := leveled.SetLevel(e.level); err != nil {
+			return nil, err
+		}
+	}
+	if seeded, ok := bot.(games.SeededStrategy); ok {
+		seeded.Seed(seed)
+	}
+
+	switch b := bot.(type) {
+	case *games.BotPlayer:
+		if err := setMinimaxOptions(b, e.options); err != nil {
+			return nil, err
+		}
+	case *games.SolverStrategy:
+		// The positions the solver doesn't play are searched by its minimax
+		// bot, to the end of the game unless it is given a depth
+		if err := setMinimaxOptions(b.Bot, e.options); err != nil {
+			return nil, err
+		}
+		if b.Bot.Settings.TimeLimitMs == timeLimitMs(0) && b.Bot.Settings.Depth == games.MaxBoardCells {
+			return nil, errors.New("solver without a time limit needs a depth")
+		}
+	case *games.MCTSPlayer:
+		for key, value := range e.options {
+			var err error
+			switch key {
+			case "iterations":
+				b.Settings.Iterations, err = strconv.Atoi(value)
+			case "time":
+				var n int
+				n, err = strconv.Atoi(value)
+				b.Settings.TimeLimitMs = timeLimitMs(n)
+			case "exploration":
+				b.Settings.Exploration, err = strconv.ParseFloat(value, 64)
+			case "heuristic":
+				b.Settings.Heuristic, err = strconv.ParseBool(value)
+			default:
+				err = errors.New("unknown mcts option")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", key, err)
+			}
+		}
+		if b.Settings.Iterations == 0 && b.Settings.TimeLimitMs == timeLimitMs(0) {
+			return nil, errors.New("mcts without a time limit needs iterations")
+		}
+	default:
+		if len(e.options) > 0 {
+			return nil, errors.New(e.strategy + " has no options")
+		}
+	}
+	return bot, nil
+}
+
+// setMinimaxOptions applies the minimax options to the bot. It searches
+// with one worker unless told otherwise, see the package comment.
+func setMinimaxOptions(b *games.BotPlayer, options map[string]string) error {
+	b.Workers = 1
+	for key, value := range options {
+		var err error
+		var n int
+		switch key {
+		case "depth":
+			// The endgame depth follows unless it is given too
+			n, err = strconv.Atoi(value)
+			b.Settings.Depth = n
+			if _, ok := options["endgame"]; !ok {
+				b.Settings.EndgameDepth = n
+			}
+		case "endgame":
+			b.Settings.EndgameDepth, err = strconv.Atoi(value)
+		case "time":
+			n, err = strconv.Atoi(value)
+			b.Settings.TimeLimitMs = timeLimitMs(n)
+		case "workers":
+			b.Workers, err = strconv.Atoi(value)
+		case "noise":
+			b.Settings.Noise, err = strconv.Atoi(value)
+		case "mistakes":
+			b.Settings.MistakeRate, err = strconv.ParseFloat(value, 64)
+		case "profile":
+			var profile games.EvalProfile
+			if strings.HasSuffix(value, ".json") {
+				profile, err = games.LoadProfile(value)
+			} else {
+				profile, err = games.GetProfile(value)
+			}
+			if err == nil {
+				b.SetProfile(profile)
+			}
+		default:
+			err = errors.New("unknown minimax option")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// timeLimitMs turns the time option into a time limit, 0 is a day
+func timeLimitMs(n int) int64 {
+	if n <= 0 {
+		return 24 * 60 * 60 * 1000
+	}
+	return int64(n)
+}
+
+// balancedOpenings returns every distinct opening of the given number of
+// drops that a shallow search scores within balance of even, shuffled by the seed
+func balancedOpenings(rules games.Rules, plies int, balance int, seed int64) [][]int {
+	// The openings all end with the same player to move
+	token := games.RedToken
+	if plies%2 == 1 {
+		token = games.YellowToken
+	}
+	judge := games.NewBotPlayer("", token)
+	judge.Workers = 1
+	judge.Settings = games.LevelSettings{Depth: 6, EndgameDepth: 6, TimeLimitMs: timeLimitMs(0)}
+
+	openings := [][]int{}
+	seen := map[string]bool{}
+	var walk func(pos games.Position, token int, moves []int)
+	walk = func(pos games.Position, token int, moves []int) {
+		if pos.HasWon(games.RedToken) || pos.HasWon(games.YellowToken) || !pos.HasLegalMove(token) {
+			return
+		}
+		next := games.YellowToken
+		if token == games.YellowToken {
+			next = games.RedToken
+		}
+		if len(moves) < plies {
+			for col := 0; col < pos.Width(); col++ {
+				if pos.CanPlay(col) {
+					child := pos
+					child.Play(col, token)
+					walk(child, next, append(append([]int{}, moves...), col))
+				}
+			}
+			return
+		}
+
+		key := games.FormatBoard(pos, token)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		scores, _ := judge.ScoreMoves(pos)
+		best := math.MinInt
+		for _, s := range scores {
+			best = max(best, s.Score)
+		}
+		if best >= -balance && best <= balance {
+			openings = append(openings, moves)
+		}
+	}
+	walk(games.NewPosition(rules), games.RedToken, []int{})
+
+	rng := rand.New(rand.NewSource(seed))
+	rng.Shuffle(len(openings), func(i, j int) {
+		openings[i], openings[j] = openings[j], openings[i]
+	})
+	return openings
+}
+
+// playGame plays one game from the opening and returns a's score, 1 for a
+// win and 0.5 for a draw. a plays red unless swapped.
+func playGame(rules games.Rules, opening []int, a, b entrant, swapped bool, seed int64) (float64, error) {
+	red, yellow := a, b
+	if swapped {
+		red, yellow = b, a
+	}
+	redBot, err := red.newBot(seed)
+	if err != nil {
+		return 0, err
+	}
+	yellowBot, err := yellow.newBot(seed + 1)
+	if err != nil {
+		return 0, err
+	}
+
+	game := games.NewGame(games.LocalMultiplayer, "red", "yellow", rules)
+	game.Start()
+	for i, col := range opening {
+		player := "red"
+		if i%2 == 1 {
+			player = "yellow"
+		}
+		if err := game.MakeMove(player, col); err != nil {
+			return 0, err
+		}
+	}
+	game.SetBot(games.RedToken, redBot)
+	game.SetBot(games.YellowToken, yellowBot)
+	for game.Status == games.StatusActive && len(game.Moves) < maxGamePlies {
+		if err := game.PlayBotMove(); err != nil {
+			return 0, err
+		}
+	}
+
+	aWon, bWon := "red", "yellow"
+	if swapped {
+		aWon, bWon = "yellow", "red"
+	}
+	switch game.WinnerID {
+	case aWon:
+		return 1, nil
+	case bWon:
+		return 0, nil
+	}
+	return 0.5, nil
+}
+
+// games returns the number of games played
+func (r result) games() int {
+	return r.wins + r.draws + r.losses
+}
+
+// stats returns the mean score per game and its variance
+func (r result) stats() (float64, float64) {
+	n := float64(r.games())
+	if n == 0 {
+		return 0.5, 0
+	}
+	score := (float64(r.wins) + float64(r.draws)/2) / n
+	variance := (float64(r.wins)*math.Pow(1-score, 2) +
+		float64(r.draws)*math.Pow(0.5-score, 2) +
+		float64(r.losses)*math.Pow(score, 2)) / n
+	return score, variance
+}
+
+// elo returns the Elo difference the score stands for and the margin of its
+// 95% confidence interval
+func (r result) elo() (float64, float64) {
+	score, variance := r.stats()
+	deviation := 1.96 * math.Sqrt(variance/float64(max(r.games(), 1)))
+	low, high := scoreToElo(score-deviation), scoreToElo(score+deviation)
+	return scoreToElo(score), (high - low) / 2
+}
+
+// llr returns the log likelihood ratio of elo1 against elo0, using the
+// normal approximation of the trinomial model
+func (r result) llr(elo0, elo1 float64) float64 {
+	score, variance := r.stats()
+	if r.games() == 0 {
+		return 0
+	}
+	// A perfect score has no spread, a small one keeps the ratio finite
+	variance = math.Max(variance, 1e-3)
+	s0, s1 := eloToScore(elo0), eloToScore(elo1)
+	return float64(r.games()) * (s1 - s0) * (2*score - s0 - s1) / (2 * variance)
+}
+
+// scoreToElo converts a mean score into an Elo difference
+func scoreToElo(score float64) float64 {
+	if score <= 0 {
+		return math.Inf(-1)
+	}
+	if score >= 1 {
+		return math.Inf(1)
+	}
+	return -400 * math.Log10(1/score-1)
+}
+
+// eloToScore converts an Elo difference into the expected mean score
+func eloToScore(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo/400))
+}
+
+// formatElo prints an Elo difference with its error bar
+func formatElo(elo, margin float64) string {
+	if math.IsInf(elo, 0) {
+		return fmt.Sprintf("%+.0f", elo)
+	}
+	if math.IsInf(margin, 0) || math.IsNaN(margin) {
+		return fmt.Sprintf("%+.0f ± inf", elo)
+	}
+	return fmt.Sprintf("%+.0f ± %.0f", elo, margin)
+}
+
+// printTable prints every entrant's results against the field, best first
+func printTable(entrants []entrant, results [][]result) {
+	type row struct {
+		spec  string
+		total result
+	}
+	rows := []row{}
+	for i, e := range entrants {
+		total := result{}
+		for j := range entrants {
+			if i != j {
+				total.wins += results[i][j].wins
+				total.draws += results[i][j].draws
+				total.losses += results[i][j].losses
+			}
+		}
+		rows = append(rows, row{spec: e.spec, total: total})
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		si, _ := rows[i].total.stats()
+		sj, _ := rows[j].total.stats()
+		return si > sj
+	})
+
+	fmt.Println()
+	fmt.Printf("%-40s %6s %6s %6s %6s %7s  %s\n", "Entrant", "Games", "Wins", "Draws", "Losses", "Score", "Elo vs field")
+	for _, r := range rows {
+		score, _ := r.total.stats()
+		elo, margin := r.total.elo()
+		fmt.Printf("%-40s %6d %6d %6d %6d %6.1f%%  %s\n", r.spec, r.total.games(), r.total.wins, r.total.draws, r.total.losses, 100*score, formatElo(elo, margin))
+	}
+
+	fmt.Println()
+	fmt.Printf("%-40s", "")
+	for i := range entrants {
+		fmt.Printf(" %11d", i+1)
+	}
+	fmt.Println()
+	for i, e := range entrants {
+		fmt.Printf("%-40s", fmt.Sprintf("%d %s", i+1, e.spec))
+		for j := range entrants {
+			if i == j {
+				fmt.Printf(" %11s", "-")
+				continue
+			}
+			r := results[i][j]
+			fmt.Printf(" %11s", fmt.Sprintf("+%d=%d-%d", r.wins, r.draws, r.losses))
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,107 @@
+// bookgen builds an opening book for the bot by searching every position
+// near the start of the game deeply, e.g.
+//
+//	go run ./cmd/bookgen -depth 6 -time 5000 -out book.json
+//
+// The server loads the book with its -book flag.
+package main
+
+import (
+	"connect4/games"
+	"flag"
+	"log"
+	"time"
+)
+
+func main() {
+	out := flag.String("out", "book.json", "File to write the book to")
+	depth := flag.Int("depth", 6, "Plies from the empty board to cover")
+	searchDepth := flag.Int("search-depth", 14, "Search depth per position")
+	timeLimit := flag.Int64("time", 5000, "Search time per position in milliseconds")
+	margin := flag.Int("margin", 30, "Also follow moves scoring at most this much below the best")
+	width := flag.Int("width", games.BoardWidth, "Board width")
+	height := flag.Int("height", games.BoardHeight, "Board height")
+	connect := flag.Int("connect", games.ConnectLength, "Discs in a line needed to win")
+	variant := flag.String("variant", string(games.VariantStandard), "Rules variant")
+	flag.Parse()
+
+	rules := games.Rules{Width: *width, Height: *height, Connect: *connect, Variant: games.Variant(*variant)}
+	if err := rules.Validate(); err != nil {
+		log.Fatalf("Invalid rules: %v", err)
+	}
+
+	// One bot per color, their tables carry over from position to position
+	settings := games.LevelSettings{Depth: *searchDepth, EndgameDepth: *searchDepth, TimeLimitMs: *timeLimit}
+	bots := map[int]*games.BotPlayer{}
+	for _, token := range []int{games.RedToken, games.YellowToken} {
+		bots[token] = games.NewBotPlayer("book", token)
+		bots[token].Settings = settings
+	}
+
+	type node struct {
+		pos   games.Position
+		token int
+		ply   int
+	}
+
+	book := games.NewBook(rules, *depth)
+	queue := []node{{pos: games.NewPosition(rules), token: games.RedToken}}
+	seen := map[string]bool{}
+	start := time.Now()
+
+	// Breadth first, so a book cut short still covers the earliest plies
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		key := games.FormatBoard(n.pos, n.token)
+		if seen[key] || n.ply >= *depth {
+			continue
+		}
+		seen[key] = true
+
+		scores, _ := bots[n.token].ScoreMoves(n.pos)
+		if len(scores) == 0 {
+			continue
+		}
+		moves := make([]games.BookMove, 0, len(scores))
+		best := scores[0].Score
+		for _, s := range scores {
+			moves = append(moves, games.BookMove{Column: s.Move.Column, Kind: s.Move.Kind, Score: s.Score})
+			best = max(best, s.Score)
+		}
+		book.Add(n.pos, n.token, moves)
+
+		// Follow the moves worth playing into positions that aren't over yet
+		next := games.RedToken
+		if n.token == games.RedToken {
+			next = games.YellowToken
+		}
+		for _, s := range scores {
+			if best-s.Score > *margin {
+				continue
+			}
+			child := n.pos
+			if s.Move.Kind == games.MovePop {
+				child.Pop(s.Move.Column)
+			} else {
+				child.Play(s.Move.Column, n.token)
+			}
+			if child.HasWon(games.RedToken) || child.HasWon(games.YellowToken) || child.IsFull() {
+				continue
+			}
+			queue = append(queue, node{pos: child, token: next, ply: n.ply + 1})
+		}
+
+		if len(book.Positions)%50 == 0 {
+			log.Printf("%d positions, ply %d, %v", len(book.Positions), n.ply, time.Since(start).Round(time.Second))
+			if err := book.Save(*out); err != nil {
+				log.Fatalf("Error saving book: %v", err)
+			}
+		}
+	}
+
+	if err := book.Save(*out); err != nil {
+		log.Fatalf("Error saving book: %v", err)
+	}
+	log.Printf("Wrote %d positions to %s in %v", len(book.Positions), *out, time.Since(start).Round(time.Second))
+}
@@ -0,0 +1,495 @@
+// tune optimizes the bot's evaluation weights with Texel's method and
+// writes them as a profile the server can load, e.g.
+//
+//	go run ./cmd/tune -positions 5000 -iterations 100 -out profiles/tuned.json
+//
+// It plays games between weak bots and labels a position from each game
+// with the solver's result. The weights are then changed one at a time, a
+// step up or down is kept if the evaluation predicts the results better.
+// The prediction of a score is 1/(1+exp(-k*score)), with k fitted to the
+// starting weights once, and the error is the mean squared error.
+//
+// The positions are saved to -data and the progress to -checkpoint, a run
+// that was stopped goes on from its checkpoint with -resume. Runs with the
+// same seed and flags give the same profile.
+package main
+
+import (
+	"connect4/games"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// weightNames are the tuned weights of a profile, in the order they are tried
+var weightNames = [...]string{"threeInRow", "twoInRow", "oneInRow", "blockThree", "blockTwo", "center"}
+
+// weights holds a profile's weights in the order of weightNames
+type weights [len(weightNames)]int
+
+// dataset is a set of labelled positions, saved so a run can be repeated without the solver
+type dataset struct {
+	Seed      int64    `json:"seed"`
+	MinPly    int      `json:"minPly"`
+	MaxPly    int      `json:"maxPly"`
+	Positions []sample `json:"positions"`
+}
+
+// sample is a position and its result with perfect play
+type sample struct {
+	Board  string  `json:"board"`  // See games.FormatBoard, with the token to move
+	Result float64 `json:"result"` // For the token to move, 1 a win, 0.5 a draw and 0 a loss
+}
+
+// checkpoint is the progress of a run
+type checkpoint struct {
+	Seed           int64     `json:"seed"`
+	DataHash       uint64    `json:"dataHash"` // Of the positions, a checkpoint only resumes on the same data
+	Start          string    `json:"start"`
+	Iteration      int       `json:"iteration"` // Iterations done
+	K              float64   `json:"k"`
+	Weights        weights   `json:"weights"`
+	Steps          weights   `json:"steps"` // Next step size of every weight
+	TrainLoss      float64   `json:"trainLoss"`
+	ValidationLoss float64   `json:"validationLoss"`
+	Converged      bool      `json:"converged"`
+	Updated        time.Time `json:"updated"`
+}
+
+// features are a position's evaluation with each weight set to 1 and the
+// others to 0. The evaluation is linear in the weights, so the evaluation
+// with any weights is the dot product of the weights and the features.
+type features [len(weightNames)]float64
+
+func main() {
+	count := flag.Int("positions", 5000, "Labelled positions to tune on")
+	minPly := flag.Int("min-ply", 12, "Fewest moves played before a position, earlier positions take the solver long")
+	maxPly := flag.Int("max-ply", 30, "Most moves played before a position")
+	seed := flag.Int64("seed", 1, "Seed for the games and the validation split")
+	workers := flag.Int("workers", runtime.NumCPU(), "Goroutines labelling positions with the solver")
+	dataPath := flag.String("data", "tune-positions.json", "File the labelled positions are kept in, empty to not keep them")
+	validation := flag.Float64("validation", 0.2, "Share of the positions held out to check the weights on")
+	iterations := flag.Int("iterations", 100, "Most passes over all the weights")
+	checkpointPath := flag.String("checkpoint", "tune-checkpoint.json", "File the progress is saved to after every pass")
+	resume := flag.Bool("resume", false, "Go on from the checkpoint")
+	start := flag.String("start", games.DefaultProfile, "Profile to start from, a name from -profiles or a profile file")
+	profileDir := flag.String("profiles", "profiles", "Directory of evaluation profiles")
+	name := flag.String("name", "tuned", "Name of the tuned profile")
+	out := flag.String("out", "tuned.json", "File to write the tuned profile to")
+	flag.Parse()
+
+	if *minPly < 0 || *maxPly < *minPly || *maxPly >= games.BoardWidth*games.BoardHeight {
+		log.Fatal("Invalid ply range")
+	}
+	if *validation < 0 || *validation >= 1 {
+		log.Fatal("Invalid validation share")
+	}
+	if _, err := games.LoadProfiles(*profileDir); err != nil {
+		log.Fatalf("Failed to load evaluation profiles: %v", err)
+	}
+	startProfile, err := loadStart(*start)
+	if err != nil {
+		log.Fatalf("Invalid start profile: %v", err)
+	}
+	tuned := startProfile
+	tuned.Name = *name
+	if err := tuned.Validate(); err != nil {
+		log.Fatalf("Invalid name: %v", err)
+	}
+
+	data, err := loadDataset(*dataPath, *seed, *count, *minPly, *maxPly, *workers)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The same seed holds out the same positions
+	samples := make([]sample, len(data.Positions))
+	copy(samples, data.Positions)
+	rand.New(rand.NewSource(*seed)).Shuffle(len(samples), func(i, j int) {
+		samples[i], samples[j] = samples[j], samples[i]
+	})
+	held := int(float64(len(samples)) * *validation)
+	train, err := extractFeatures(samples[held:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	check, err := extractFeatures(samples[:held])
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d positions to tune on, %d held out", len(train.features), len(check.features))
+
+	cp := checkpoint{Seed: *seed, DataHash: hashDataset(data), Start: *start, Weights: weightsOf(startProfile)}
+	if *resume {
+		if cp, err = loadCheckpoint(*checkpointPath, cp); err != nil {
+			log.Fatalf("Can't resume: %v", err)
+		}
+		log.Printf("Resuming after iteration %d, error %.6f", cp.Iteration, cp.TrainLoss)
+	} else {
+		cp.K = fitK(train, cp.Weights)
+		for i, w := range cp.Weights {
+			cp.Steps[i] = max(1, w/4)
+		}
+		cp.TrainLoss = train.loss(cp.Weights, cp.K)
+		cp.ValidationLoss = check.loss(cp.Weights, cp.K)
+		log.Printf("k %.3g, error %.6f, held out %.6f", cp.K, cp.TrainLoss, cp.ValidationLoss)
+	}
+
+	for !cp.Converged && cp.Iteration < *iterations {
+		texelPass(train, &cp)
+		cp.Iteration++
+		cp.ValidationLoss = check.loss(cp.Weights, cp.K)
+		log.Printf("Iteration %d: error %.6f, held out %.6f, %s", cp.Iteration, cp.TrainLoss, cp.ValidationLoss, formatWeights(cp.Weights))
+		if *checkpointPath != "" {
+			if err := saveCheckpoint(*checkpointPath, cp); err != nil {
+				log.Fatalf("Failed to save the checkpoint: %v", err)
+			}
+		}
+	}
+	if cp.Converged {
+		log.Printf("Converged after %d iterations", cp.Iteration)
+	}
+
+	tuned = withWeights(tuned, cp.Weights)
+	tuned.Description = fmt.Sprintf("Tuned from %s on %d positions with seed %d, held out error %.4f", *start, len(train.features), *seed, cp.ValidationLoss)
+	if err := tuned.Validate(); err != nil {
+		log.Fatalf("Tuned profile is invalid: %v", err)
+	}
+	if err := tuned.Save(*out); err != nil {
+		log.Fatalf("Failed to write the profile: %v", err)
+	}
+	log.Printf("Wrote profile %s to %s", tuned.Name, *out)
+}
+
+// texelPass tries a step up and down for every weight and keeps the first
+// that lowers the error. The step of a weight that got better is doubled for
+// the next pass, the step of one that didn't is halved. It marks the
+// checkpoint converged once no step of size 1 helps.
+func texelPass(train *featureSet, cp *checkpoint) {
+	improved := false
+	for i := range cp.Weights {
+		better := false
+		for _, dir := range []int{1, -1} {
+			candidate := cp.Weights
+			candidate[i] = min(max(candidate[i]+dir*cp.Steps[i], 0), games.MaxProfileWeight)
+			if candidate[i] == cp.Weights[i] {
+				continue
+			}
+			if loss := train.loss(candidate, cp.K); loss < cp.TrainLoss {
+				cp.Weights, cp.TrainLoss = candidate, loss
+				better = true
+				break
+			}
+		}
+		if better {
+			cp.Steps[i] = min(cp.Steps[i]*2, games.MaxProfileWeight/4)
+			improved = true
+		} else if cp.Steps[i] > 1 {
+			cp.Steps[i] /= 2
+			improved = true // Smaller steps are still to be tried
+		}
+	}
+	cp.Converged = !improved
+}
+
+// featureSet holds the features and results of positions
+type featureSet struct {
+	features []features
+	results  []float64
+}
+
+// loss returns the mean squared error of the predicted results
+func (s *featureSet) loss(w weights, k float64) float64 {
+	if len(s.features) == 0 {
+		return 0
+	}
+	total := 0.0
+	for i, f := range s.features {
+		score := 0.0
+		for j := range f {
+			score += float64(w[j]) * f[j]
+		}
+		diff := s.results[i] - 1/(1+math.Exp(-k*score))
+		total += diff * diff
+	}
+	return total / float64(len(s.features))
+}
+
+// fitK finds the scale of the prediction that fits the weights best, by a
+// coarse scan of its logarithm and a finer one around the best value
+func fitK(train *featureSet, w weights) float64 {
+	best, bestLoss := 0.0, math.Inf(1)
+	for _, step := range []float64{0.1, 0.01} {
+		from, to := -7.0, 0.0
+		if best != 0 {
+			from, to = math.Log10(best)-0.1, math.Log10(best)+0.1
+		}
+		for x := from; x <= to+step/2; x += step {
+			k := math.Pow(10, x)
+			if loss := train.loss(w, k); loss < bestLoss {
+				best, bestLoss = k, loss
+			}
+		}
+	}
+	return best
+}
+
+// extractFeatures evaluates the positions once per weight
+func extractFeatures(samples []sample) (*featureSet, error) {
+	rules := games.Rules{}.WithDefaults()
+	set := &featureSet{}
+	bots := map[int]*games.BotPlayer{}
+	for _, token := range []int{games.RedToken, games.YellowToken} {
+		bots[token] = games.NewBotPlayer("tune", token)
+	}
+	for _, s := range samples {
+		pos, token, err := games.ParseBoard(s.Board, rules)
+		if err != nil {
+			return nil, fmt.Errorf("invalid position %q: %v", s.Board, err)
+		}
+		var f features
+		for i := range weightNames {
+			var unit weights
+			unit[i] = 1
+			bots[token].SetProfile(withWeights(games.EvalProfile{Name: "unit"}, unit))
+			f[i] = float64(bots[token].Evaluate(pos))
+		}
+		set.features = append(set.features, f)
+		set.results = append(set.results, s.Result)
+	}
+	return set, nil
+}
+
+// loadDataset reads the positions from the data file, or makes them and
+// writes them there if it doesn't exist
+func loadDataset(path string, seed int64, count, minPly, maxPly, workers int) (*dataset, error) {
+	if path != "" {
+		if raw, err := os.ReadFile(path); err == nil {
+			data := &dataset{}
+			if err := json.Unmarshal(raw, data); err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			if data.Seed != seed || data.MinPly != minPly || data.MaxPly != maxPly || len(data.Positions) != count {
+				return nil, fmt.Errorf("%s was made with other flags, remove it or pass another -data", path)
+			}
+			log.Printf("Read %d positions from %s", len(data.Positions), path)
+			return data, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	data := &dataset{Seed: seed, MinPly: minPly, MaxPly: maxPly, Positions: makePositions(seed, count, minPly, maxPly)}
+	labelPositions(data.Positions, workers)
+	if path != "" {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, raw, 0644); err != nil {
+			return nil, err
+		}
+		log.Printf("Wrote %d positions to %s", len(data.Positions), path)
+	}
+	return data, nil
+}
+
+// makePositions plays games between two weak, noisy bots after a few random
+// moves and takes one position from each, after a random number of moves.
+// Positions that are over, won on the next move or already taken are skipped.
+func makePositions(seed int64, count, minPly, maxPly int) []sample {
+	rules := games.Rules{}.WithDefaults()
+	rng := rand.New(rand.NewSource(seed))
+	settings := games.LevelSettings{Depth: 2, EndgameDepth: 2, TimeLimitMs: 24 * 60 * 60 * 1000, Noise: 150, MistakeRate: 0.1}
+
+	samples := []sample{}
+	seen := map[string]bool{}
+	for len(samples) < count {
+		bots := map[int]*games.BotPlayer{}
+		for _, token := range []int{games.RedToken, games.YellowToken} {
+			bots[token] = games.NewBotPlayer("tune", token)
+			bots[token].Settings = settings
+			bots[token].Workers = 1
+			bots[token].Seed(rng.Int63())
+		}
+
+		pos := games.NewPosition(rules)
+		token := games.RedToken
+		plies := minPly + rng.Intn(maxPly-minPly+1)
+		randomPlies := rng.Intn(5)
+		over := false
+		for ply := 0; ply < plies && !over; ply++ {
+			var col int
+			if ply < randomPlies {
+				col = randomColumn(pos, rng)
+			} else {
+				col = bots[token].ChooseMove(pos, token, games.Budget{}).Column
+			}
+			pos.Play(col, token)
+			over = pos.HasWon(token) || pos.IsFull()
+			token = otherToken(token)
+		}
+
+		key := games.FormatBoard(pos, token)
+		if over || seen[key] || canWinNow(pos, token) {
+			continue
+		}
+		seen[key] = true
+		samples = append(samples, sample{Board: key})
+	}
+	return samples
+}
+
+// labelPositions solves every position, the workers share the positions but
+// each has its own solver
+func labelPositions(samples []sample, workers int) {
+	rules := games.Rules{}.WithDefaults()
+	start := time.Now()
+	jobs := make(chan int)
+	var done sync.WaitGroup
+	var progress sync.Mutex
+	labelled := 0
+	for w := 0; w < max(workers, 1); w++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			solver := games.NewSolver()
+			for i := range jobs {
+				pos, token, _ := games.ParseBoard(samples[i].Board, rules)
+				result, err := solver.Solve(pos, token, time.Time{})
+				if err != nil {
+					log.Fatalf("Failed to solve %s: %v", samples[i].Board, err)
+				}
+				switch result.Value {
+				case games.SolveWin:
+					samples[i].Result = 1
+				case games.SolveDraw:
+					samples[i].Result = 0.5
+				}
+
+				progress.Lock()
+				labelled++
+				if labelled%500 == 0 {
+					log.Printf("Labelled %d of %d positions in %v", labelled, len(samples), time.Since(start).Round(time.Second))
+				}
+				progress.Unlock()
+			}
+		}()
+	}
+	for i := range samples {
+		jobs <- i
+	}
+	close(jobs)
+	done.Wait()
+}
+
+// loadStart returns the profile named by the -start flag
+func loadStart(start string) (games.EvalProfile, error) {
+	if strings.HasSuffix(start, ".json") {
+		return games.LoadProfile(start)
+	}
+	return games.GetProfile(start)
+}
+
+// loadCheckpoint reads a checkpoint and checks it belongs to the same run as fresh
+func loadCheckpoint(path string, fresh checkpoint) (checkpoint, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return checkpoint{}, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(raw, &cp); err != nil {
+		return checkpoint{}, err
+	}
+	if cp.Seed != fresh.Seed || cp.DataHash != fresh.DataHash || cp.Start != fresh.Start {
+		return checkpoint{}, errors.New("the checkpoint was made with another seed, start or positions")
+	}
+	return cp, nil
+}
+
+// saveCheckpoint writes the checkpoint to a temporary file first, so a run
+// stopped while writing keeps the previous checkpoint
+func saveCheckpoint(path string, cp checkpoint) error {
+	cp.Updated = time.Now()
+	raw, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// hashDataset fingerprints the positions and their results
+func hashDataset(data *dataset) uint64 {
+	h := fnv.New64a()
+	for _, s := range data.Positions {
+		fmt.Fprintf(h, "%s %g\n", s.Board, s.Result)
+	}
+	return h.Sum64()
+}
+
+// weightsOf returns the tuned weights of a profile
+func weightsOf(p games.EvalProfile) weights {
+	return weights{p.ThreeInRow, p.TwoInRow, p.OneInRow, p.BlockThree, p.BlockTwo, p.Center}
+}
+
+// withWeights returns the profile with its tuned weights replaced
+func withWeights(p games.EvalProfile, w weights) games.EvalProfile {
+	p.ThreeInRow, p.TwoInRow, p.OneInRow, p.BlockThree, p.BlockTwo, p.Center = w[0], w[1], w[2], w[3], w[4], w[5]
+	return p
+}
+
+// formatWeights lists the weights by name
+func formatWeights(w weights) string {
+	parts := make([]string, len(w))
+	for i, name := range weightNames {
+		parts[i] = fmt.Sprintf("%s=%d", name, w[i])
+	}
+	return strings.Join(parts, " ")
+}
+
+// randomColumn returns a random column that isn't full
+func randomColumn(pos games.Position, rng *rand.Rand) int {
+	cols := []int{}
+	for col := 0; col < pos.Width(); col++ {
+		if pos.CanPlay(col) {
+			cols = append(cols, col)
+		}
+	}
+	return cols[rng.Intn(len(cols))]
+}
+
+// canWinNow tells whether the token wins with its next disc
+func canWinNow(pos games.Position, token int) bool {
+	for col := 0; col < pos.Width(); col++ {
+		if !pos.CanPlay(col) {
+			continue
+		}
+		next := pos
+		next.Play(col, token)
+		if next.HasWon(token) {
+			return true
+		}
+	}
+	return false
+}
+
+// otherToken returns the opponent's token
+func otherToken(token int) int {
+	if token == games.RedToken {
+		return games.YellowToken
+	}
+	return games.RedToken
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"connect4/games"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Every player can spend HintBudget of bot search time on hints, it refills
+// at the same amount per HintRefill. Cached answers are free.
+const (
+	HintBudget     = 30 * time.Second
+	HintRefill     = time.Hour
+	maxCachedHints = 10000
+)
+
+// ErrHintBudget is returned when a player asks for more hints than their budget allows
+var ErrHintBudget = errors.New("hint budget used up, try again later")
+
+// analysis cache, rules and board snapshot -> analysis
+var (
+	analysisCache = make(map[string]games.Analysis)
+	analysisMutex = &sync.Mutex{}
+)
+
+// hint budgets, player id -> search time left
+type hintBudget struct {
+	left    time.Duration
+	updated time.Time
+}
+
+var (
+	hintBudgets = make(map[string]*hintBudget)
+	budgetMutex = &sync.Mutex{}
+)
+
+// AnalyzeGame analyzes the current position of a game for one of its players.
+// Online games can only be analyzed once they are over, so hints can't be
+// used against another person. The caller must not hold the game's lock.
+func AnalyzeGame(game *games.Game, playerID string) (games.Analysis, error) {
+	game.Lock()
+	if playerID == "" || (playerID != game.Player1ID && playerID != game.Player2ID) {
+		game.Unlock()
+		return games.Analysis{}, errors.New("player is not in this game")
+	}
+	// Budgets are kept by player ID, a bot's seat would be a second budget
+	if games.IsBotID(playerID) {
+		game.Unlock()
+		return games.Analysis{}, errors.New("hints are for players, not bots")
+	}
+	if game.Type == games.OnlineMultiplayer && game.Status != games.StatusFinished {
+		game.Unlock()
+		return games.Analysis{}, errors.New("hints are not allowed in online games")
+	}
+	board, token, rules := game.Board, game.CurrentTurn, game.Rules
+	game.Unlock()
+
+	key := fmt.Sprintf("%dx%d/%d/%s %s", rules.Width, rules.Height, rules.Connect, rules.Variant, games.FormatBoard(board, token))
+	analysisMutex.Lock()
+	analysis, ok := analysisCache[key]
+	analysisMutex.Unlock()
+	if ok {
+		return analysis, nil
+	}
+
+	// The longest search is taken off the budget before it starts, so hints
+	// asked for at the same time can't overdraw it, and the rest is given back
+	reserved := time.Duration(games.AnalysisSettings.TimeLimitMs) * time.Millisecond
+	if err := reserveHintBudget(playerID, reserved); err != nil {
+		return games.Analysis{}, err
+	}
+	start := time.Now()
+	analysis, err := games.AnalyzePosition(board, token)
+	chargeHintBudget(playerID, time.Since(start)-reserved)
+	if err != nil {
+		return games.Analysis{}, err
+	}
+
+	analysisMutex.Lock()
+	if len(analysisCache) >= maxCachedHints {
+		analysisCache = make(map[string]games.Analysis)
+	}
+	analysisCache[key] = analysis
+	analysisMutex.Unlock()
+	return analysis, nil
+}
+
+// reserveHintBudget takes the search time off the player's hint budget, it
+// fails if less than that is left
+func reserveHintBudget(playerID string, reserved time.Duration) error {
+	budgetMutex.Lock()
+	defer budgetMutex.Unlock()
+
+	budget := refillHintBudget(playerID)
+	if budget.left < reserved {
+		return ErrHintBudget
+	}
+	budget.left -= reserved
+	return nil
+}
+
+// chargeHintBudget takes search time off the player's hint budget, a
+// negative time gives it back
+func chargeHintBudget(playerID string, used time.Duration) {
+	budgetMutex.Lock()
+	defer budgetMutex.Unlock()
+
+	budget := refillHintBudget(playerID)
+	budget.left = min(budget.left-used, HintBudget)
+}
+
+// refillHintBudget returns the player's budget, topped up for the time since
+// it was last used. The caller must hold budgetMutex.
+func refillHintBudget(playerID string) *hintBudget {
+	now := time.Now()
+	budget, ok := hintBudgets[playerID]
+	if !ok {
+		budget = &hintBudget{left: HintBudget, updated: now}
+		hintBudgets[playerID] = budget
+	}
+
+	budget.left += time.Duration(float64(HintBudget) * float64(now.Sub(budget.updated)) / float64(HintRefill))
+	if budget.left > HintBudget {
+		budget.left = HintBudget
+	}
+	budget.updated = now
+	return budget
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"connect4/games"
+	"errors"
+	"log"
+)
+
+// BotThinking is sent to everyone in a game when the bot starts on its move
+type BotThinking struct {
+	PlayerID string `json:"playerId"`
+	Token    int    `json:"token"`
+}
+
+// ScheduleBotMove lets the bot reply in the background if it is the bot's
+// turn, so a WebSocket's read loop isn't held up by the search. Everyone in
+// the game is told the bot is thinking and then gets the game state with its
+// move. A move found after the game was reset or changed is thrown away and
+// the bot starts again if it is still its turn.
+// The caller must hold the game's lock.
+func ScheduleBotMove(game *games.Game) {
+	turn, err := game.StartBotTurn()
+	if err != nil {
+		return
+	}
+
+	playerID := game.Player1ID
+	if turn.Token == games.YellowToken {
+		playerID = game.Player2ID
+	}
+	broadcastMessage(game.ID, TypeBotThinking, BotThinking{PlayerID: playerID, Token: turn.Token})
+
+	go func() {
+		move := turn.Choose()
+
+		game.Lock()
+		defer game.Unlock()
+		if err := game.FinishBotTurn(turn, move); err != nil {
+			if errors.Is(err, games.ErrStaleBotTurn) {
+				// The bot may still have to move in the new position, unless
+				// another turn is already on it. Exhibitions schedule their
+				// own moves, see continueExhibition.
+				log.Printf("Dropped the bot's move in game %s: %v", game.ID, err)
+				if !isExhibition(game.ID) {
+					ScheduleBotMove(game)
+				}
+			} else {
+				log.Printf("Bot move error in game %s: %v", game.ID, err)
+			}
+			return
+		}
+		SaveAndBroadcast(game)
+		continueExhibition(game)
+	}()
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"connect4/games"
+	"log"
+	"sync"
+	"time"
+)
+
+// flag timers, game id -> timer firing when the player to move runs out of time
+var (
+	clockTimers = make(map[string]*time.Timer)
+	clockMutex  = &sync.Mutex{}
+)
+
+// ScheduleClock (re)arms the server timer for a timed game. It must be called
+// whenever the running clock changes: when the game starts, after every move,
+// reset and undo. The caller must hold the game's lock.
+func ScheduleClock(game *games.Game) {
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+
+	if timer, ok := clockTimers[game.ID]; ok {
+		timer.Stop()
+		delete(clockTimers, game.ID)
+	}
+
+	if game.Clock == nil || game.Status != games.StatusActive || game.Clock.Running() == games.EmptyCell {
+		return
+	}
+
+	gameID := game.ID
+	clockTimers[gameID] = time.AfterFunc(time.Until(game.Clock.FlagTime()), func() {
+		checkFlag(gameID)
+	})
+}
+
+// checkFlag ends a game whose player to move ran out of time, even if nobody
+// sends a message. If the clock moved on in the meantime it is rescheduled.
+func checkFlag(gameID string) {
+	game, err := GetGame(gameID)
+	if err != nil {
+		return
+	}
+
+	game.Lock()
+	defer game.Unlock()
+
+	if !game.CheckFlag(time.Now()) {
+		ScheduleClock(game)
+		return
+	}
+
+	log.Printf("Game %s ended on time, winner: %s", gameID, game.WinnerID)
+	SaveAndBroadcast(game)
+}
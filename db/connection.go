@@ -22,6 +22,15 @@ const (
 	TypeResetRequest MessageType = "resetRequest"  // New: First player requests reset
     TypeResetConfirm MessageType = "resetConfirm"
 	TypeResetGame MessageType = "resetGame"
+	TypeUndoRequest MessageType = "undoRequest" // Player asks the opponent to take back the last move
+	TypeUndoConfirm MessageType = "undoConfirm" // Opponent accepts or rejects the takeback
+	TypeResign MessageType = "resign"
+	TypeOfferDraw MessageType = "offerDraw"     // Open until answered or the offering player moves again
+	TypeAcceptDraw MessageType = "acceptDraw"
+	TypeDeclineDraw MessageType = "declineDraw"
+	TypeHint MessageType = "hint"         // Player asks for the bot's analysis of the position
+	TypeAnalysis MessageType = "analysis" // Answer to a hint, only sent to the asking connection
+	TypeBotThinking MessageType = "botThinking" // The bot started on its move, a gameState follows
 
 )
 
@@ -48,6 +57,17 @@ var (
    
 )
 
+// pending takeback requests, game id -> request
+type undoRequest struct {
+	PlayerID string
+	Version  int // Game version when the takeback was requested, see games.Game.Version
+}
+
+var (
+	pendingUndos = make(map[string]undoRequest)
+	undoMutex    = &sync.Mutex{}
+)
+
 // Add these functions to manage player connections
 func RegisterPlayerConnection(playerID string, conn *websocket.Conn) {
     playerMutex.Lock()
@@ -119,10 +139,6 @@ func RemoveGameConnection(gameID string, conn *websocket.Conn){
 // this function handle the websocket msg, for typegamestate messages, defined earlier
 func BroadcastGameState(gameID string, game *games.Game){
 	log.Printf("Broadcasting game state for game: %s", gameID)
-	connMutex.Lock()
-	conns := connections[gameID]
-	defer connMutex.Unlock()
-
 	gameJson, err := json.Marshal(game)
 	if err != nil{
 		log.Printf("Error in marshalling game state : %v", err)
@@ -138,14 +154,26 @@ func BroadcastGameState(gameID string, game *games.Game){
 		return
 	}
 
-	for _, conn := range conns {
+	connMutex.Lock()
+	var failed []*websocket.Conn
+	for _, conn := range connections[gameID] {
 		err := conn.WriteMessage(websocket.TextMessage, messageJson)
 		if err != nil{
 			log.Printf("Error sending message: %v", err)
-			conn.Close()
-			RemoveGameConnection(gameID, conn)
+			failed = append(failed, conn)
 		}
 	}
+	connMutex.Unlock()
+	dropGameConnections(gameID, failed)
+}
+
+// dropGameConnections closes connections a message couldn't be sent to.
+// The caller must not hold connMutex, RemoveGameConnection takes it.
+func dropGameConnections(gameID string, conns []*websocket.Conn) {
+	for _, conn := range conns {
+		conn.Close()
+		RemoveGameConnection(gameID, conn)
+	}
 }
 
 // function to process all the incoming messages for a game
@@ -172,7 +200,7 @@ func HandleConnection(gameID string, conn *websocket.Conn){
 	// 
 	go func ()  {
 		for range ticker.C {
-			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
 				return
 			}
 		}
@@ -201,179 +229,327 @@ func HandleConnection(gameID string, conn *websocket.Conn){
 			continue
 		}
 
-		// handle message with message type
-		switch message.Type{
-		case TypeMove:
-			var move games.Move
-			if err := json.Unmarshal(message.Payload, &move); err != nil {
-				log.Printf("Error unmarshaling move : %v", err)
-				continue
-			}
-			log.Printf("Received move from player %s: %v", move.PlayerID, move)
-			//now we have the move, so we make the move
-			if err := game.MakeMove(move.PlayerID, move.Column); err != nil{
-				
-				errMsg := ErrorMessage{Error: err.Error()}
-				errJson, _ := json.Marshal(errMsg)
-				response := Message{
-					Type: TypeError,
-					Payload: errJson,
-				}
-				responseJson, _ := json.Marshal(response)
-				conn.WriteMessage(websocket.TextMessage, responseJson)
-				continue
-			}
-			// after making the move, save the game state
-			if err := SaveGame(game); err != nil{
-				log.Printf("Error in saving the game : %v" , err)
-			}
+		game.Lock()
+		handleGameMessage(gameID, conn, game, message)
+		game.Unlock()
 
-			// broadcast the game status
-			BroadcastGameState(gameID, game)
 
-			// TODO: if game is against the bot, make the bot move
+	}
 
-			// check if game finished
-			if game.Status == games.StatusFinished{
-				updatePlayerStats(game)
-			}
 
-		case TypeJoinGame:
+}
 
-			var joinRequest struct {
-				PlayerID string `json:"playerId"`
-			}
-			if err := json.Unmarshal(message.Payload, &joinRequest); err != nil {
-				log.Printf("Error unmarshaling join request: %v", err)
-				continue
-			}
-			
-			// Update the game with the second player
-			game.Player2ID = joinRequest.PlayerID
-			game.Status = games.StatusActive
-			
-			log.Printf("Player %s joined game %s", joinRequest.PlayerID, gameID)
+// handleGameMessage processes one message for a game, the caller holds the game's lock
+func handleGameMessage(gameID string, conn *websocket.Conn, game *games.Game, message Message) {
+	// handle message with message type
+	switch message.Type{
+	case TypeMove:
+		var move games.Move
+		if err := json.Unmarshal(message.Payload, &move); err != nil {
+			log.Printf("Error unmarshaling move : %v", err)
+			return
+		}
+		log.Printf("Received move from player %s: %v", move.PlayerID, move)
+		//now we have the move, so we make the move
+		if err := game.ApplyMove(move); err != nil{
+			sendErrorMessage(conn, err.Error())
+			return
+		}
+		// after making the move, save the game state
+		if err := SaveGame(game); err != nil{
+			log.Printf("Error in saving the game : %v" , err)
+		}
+		ScheduleClock(game)
+
+		// broadcast the game status
+		BroadcastGameState(gameID, game)
+
+		// if game is against the bot, the bot replies in the background
+		ScheduleBotMove(game)
+
+		// check if game finished
+		if game.Status == games.StatusFinished{
+			UpdatePlayerStats(game)
+		}
+
+	case TypeJoinGame:
+
+		var joinRequest struct {
+			PlayerID string `json:"playerId"`
+		}
+		if err := json.Unmarshal(message.Payload, &joinRequest); err != nil {
+			log.Printf("Error unmarshaling join request: %v", err)
+			return
+		}
+		
+		// Update the game with the second player, a running clock isn't restarted
+		game.Player2ID = joinRequest.PlayerID
+		if game.Status != games.StatusActive {
+			game.Start()
+		}
+		
+		log.Printf("Player %s joined game %s", joinRequest.PlayerID, gameID)
+		
+		// Save the updated game
+		if err := SaveGame(game); err != nil {
+			log.Printf("Error saving game after join: %v", err)
+			sendErrorMessage(conn, "Failed to save game after join")
+			return
+		}
+		
+		ScheduleClock(game)
+		
+		// Broadcast the updated game state to all clients
+		BroadcastGameState(gameID, game)
+		ScheduleBotMove(game)
+	case TypeResetRequest:
+        // Handle reset game request
+        log.Printf("Received reset game request for game: %s", gameID)
+        
+        // Parse the reset request
+        var resetRequest struct {
+            PlayerID string `json:"playerId"`
+        }
+        if err := json.Unmarshal(message.Payload, &resetRequest); err != nil {
+            log.Printf("Error unmarshaling reset request: %v", err)
+            return
+        }
+        
+        // Verify player is in this game
+        if resetRequest.PlayerID != game.Player1ID && resetRequest.PlayerID != game.Player2ID {
+            log.Printf("Player %s not in game %s", resetRequest.PlayerID, gameID)
+            sendErrorMessage(conn, "You are not a player in this game")
+            return
+        }
+        
+        // Reset the game state
+        if resetRequest.PlayerID != game.Player1ID && resetRequest.PlayerID != game.Player2ID {
+            log.Printf("Player %s not in game %s", resetRequest.PlayerID, gameID)
+            sendErrorMessage(conn, "You are not a player in this game")
+            return
+        }
+        
+        // Determine the other player's ID
+        otherPlayerID := game.Player1ID
+        if resetRequest.PlayerID == game.Player1ID {
+            otherPlayerID = game.Player2ID
+        }
+        
+        log.Printf("Player %s requested game reset, waiting for confirmation from %s", 
+                  resetRequest.PlayerID, otherPlayerID)
+        
+        // Broadcast reset request other player for this game
+        BroadcastResetRequest(gameID, otherPlayerID, resetRequest.PlayerID)
+	case TypeResetConfirm:
+        // Handle reset confirmation from the other player
+        var resetConfirm struct {
+            PlayerID string `json:"playerId"`
+            Confirm  bool   `json:"confirm"`
+        }
+        if err := json.Unmarshal(message.Payload, &resetConfirm); err != nil {
+            log.Printf("Error unmarshaling reset confirmation: %v", err)
+            return
+        }
+        
+        // Verify player is in this game
+        if resetConfirm.PlayerID != game.Player1ID && resetConfirm.PlayerID != game.Player2ID {
+            log.Printf("Player %s not in game %s", resetConfirm.PlayerID, gameID)
+            sendErrorMessage(conn, "You are not a player in this game")
+            return
+        }
+        
+        if resetConfirm.Confirm {
+            // Reset confirmed, reset the game
+			startingToken := games.RedToken
+			if ( game.WinnerID == game.Player2ID){
+				startingToken = games.YellowToken
+				}
+			game.Reset(startingToken)
+			undoMutex.Lock()
+			delete(pendingUndos, gameID)
+			undoMutex.Unlock()
 			
 			// Save the updated game
 			if err := SaveGame(game); err != nil {
-				log.Printf("Error saving game after join: %v", err)
-				
-				// Send error response
-				errMsg := ErrorMessage{Error: "Failed to save game after join"}
-				errJson, _ := json.Marshal(errMsg)
-				response := Message{
-					Type: TypeError,
-					Payload: errJson,
-				}
-				responseJson, _ := json.Marshal(response)
-				conn.WriteMessage(websocket.TextMessage, responseJson)
-				continue
+				log.Printf("Error saving game after reset: %v", err)
+				sendErrorMessage(conn, "Failed to reset game")
+				return
 			}
-			
-			// Broadcast the updated game state to all clients
-			BroadcastGameState(gameID, game)
-		case TypeResetRequest:
-            // Handle reset game request
-            log.Printf("Received reset game request for game: %s", gameID)
-            
-            // Parse the reset request
-            var resetRequest struct {
-                PlayerID string `json:"playerId"`
-            }
-            if err := json.Unmarshal(message.Payload, &resetRequest); err != nil {
-                log.Printf("Error unmarshaling reset request: %v", err)
-                continue
-            }
-            
-            // Verify player is in this game
-            if resetRequest.PlayerID != game.Player1ID && resetRequest.PlayerID != game.Player2ID {
-                log.Printf("Player %s not in game %s", resetRequest.PlayerID, gameID)
-                sendErrorMessage(conn, "You are not a player in this game")
-                continue
-            }
-            
-            // Reset the game state
-            if resetRequest.PlayerID != game.Player1ID && resetRequest.PlayerID != game.Player2ID {
-                log.Printf("Player %s not in game %s", resetRequest.PlayerID, gameID)
-                sendErrorMessage(conn, "You are not a player in this game")
-                continue
-            }
-            
-            // Determine the other player's ID
-            otherPlayerID := game.Player1ID
-            if resetRequest.PlayerID == game.Player1ID {
-                otherPlayerID = game.Player2ID
-            }
-            
-            log.Printf("Player %s requested game reset, waiting for confirmation from %s", 
-                      resetRequest.PlayerID, otherPlayerID)
-            
-            // Broadcast reset request other player for this game
-            BroadcastResetRequest(gameID, otherPlayerID, resetRequest.PlayerID)
-		case TypeResetConfirm:
-            // Handle reset confirmation from the other player
-            var resetConfirm struct {
-                PlayerID string `json:"playerId"`
-                Confirm  bool   `json:"confirm"`
-            }
-            if err := json.Unmarshal(message.Payload, &resetConfirm); err != nil {
-                log.Printf("Error unmarshaling reset confirmation: %v", err)
-                continue
-            }
-            
-            // Verify player is in this game
-            if resetConfirm.PlayerID != game.Player1ID && resetConfirm.PlayerID != game.Player2ID {
-                log.Printf("Player %s not in game %s", resetConfirm.PlayerID, gameID)
-                sendErrorMessage(conn, "You are not a player in this game")
-                continue
-            }
-            
-            if resetConfirm.Confirm {
-                // Reset confirmed, reset the game
-                game.Status = games.StatusActive
-				game.Board = games.NewBoard()
-				game.CurrentTurn = games.RedToken
-				if ( game.WinnerID == game.Player2ID){
-					game.CurrentTurn= games.YellowToken
-					}
-				game.WinnerID = ""
-				game.LastMoveTime = time.Now()
-				
-				// Save the updated game
-				if err := SaveGame(game); err != nil {
-					log.Printf("Error saving game after reset: %v", err)
-					sendErrorMessage(conn, "Failed to reset game")
-					continue
-				}
-				sendMessageTo := resetConfirm.PlayerID
-				if resetConfirm.PlayerID == game.Player1ID {
-					sendMessageTo = game.Player2ID
-				}
-                log.Printf("Game %s has been reset after confirmation from %s", 
-                          gameID, sendMessageTo)
-                
-                // Broadcast the updated game state to all clients
-				BroadcastResetGame(gameID)
-                BroadcastGameState(gameID, game)
-            } else {
-                // Reset rejected, notify the other player
-                BroadcastResetRejected(gameID, resetConfirm.PlayerID)
-            }
-            
+			ScheduleClock(game)
+			sendMessageTo := resetConfirm.PlayerID
+			if resetConfirm.PlayerID == game.Player1ID {
+				sendMessageTo = game.Player2ID
+			}
+            log.Printf("Game %s has been reset after confirmation from %s", 
+                      gameID, sendMessageTo)
             
-        
+            // Broadcast the updated game state to all clients
+			BroadcastResetGame(gameID)
+            BroadcastGameState(gameID, game)
+			ScheduleBotMove(game)
+        } else {
+            // Reset rejected, notify the other player
+            BroadcastResetRejected(gameID, resetConfirm.PlayerID)
+        }
+	case TypeUndoRequest:
+		var undoReq struct {
+			PlayerID string `json:"playerId"`
+		}
+		if err := json.Unmarshal(message.Payload, &undoReq); err != nil {
+			log.Printf("Error unmarshaling undo request: %v", err)
+			return
+		}
+
+		// Verify player is in this game
+		if undoReq.PlayerID != game.Player1ID && undoReq.PlayerID != game.Player2ID {
+			sendErrorMessage(conn, "You are not a player in this game")
+			return
+		}
+		if game.Type != games.OnlineMultiplayer {
+			sendErrorMessage(conn, "Takebacks in this game don't need confirmation, use the undo endpoint")
+			return
+		}
+		if len(game.Moves) == 0 {
+			sendErrorMessage(conn, "There are no moves to undo")
+			return
+		}
+		if game.Status == games.StatusFinished {
+			sendErrorMessage(conn, "The game is over")
+			return
+		}
+		// Only the player who made the last move can ask to take it back
+		if game.Moves[len(game.Moves)-1].PlayerID != undoReq.PlayerID {
+			sendErrorMessage(conn, "Only the player who made the last move can take it back")
+			return
 		}
 
+		otherPlayerID := game.Player1ID
+		if undoReq.PlayerID == game.Player1ID {
+			otherPlayerID = game.Player2ID
+		}
 
-	}
+		undoMutex.Lock()
+		pendingUndos[gameID] = undoRequest{PlayerID: undoReq.PlayerID, Version: game.Version()}
+		undoMutex.Unlock()
+
+		log.Printf("Player %s requested a takeback, waiting for confirmation from %s",
+			undoReq.PlayerID, otherPlayerID)
+		BroadcastUndoRequest(gameID, otherPlayerID, undoReq.PlayerID)
+	case TypeUndoConfirm:
+		var undoConfirm struct {
+			PlayerID string `json:"playerId"`
+			Confirm  bool   `json:"confirm"`
+		}
+		if err := json.Unmarshal(message.Payload, &undoConfirm); err != nil {
+			log.Printf("Error unmarshaling undo confirmation: %v", err)
+			return
+		}
+
+		// Verify player is in this game
+		if undoConfirm.PlayerID != game.Player1ID && undoConfirm.PlayerID != game.Player2ID {
+			sendErrorMessage(conn, "You are not a player in this game")
+			return
+		}
+
+		// Only the opponent of the requesting player can answer, and only
+		// while the position is still the one the takeback was asked for
+		undoMutex.Lock()
+		request, ok := pendingUndos[gameID]
+		if ok && request.PlayerID != undoConfirm.PlayerID {
+			delete(pendingUndos, gameID)
+		}
+		undoMutex.Unlock()
+		if !ok || request.PlayerID == undoConfirm.PlayerID {
+			sendErrorMessage(conn, "There is no takeback request to answer")
+			return
+		}
+		if request.Version != game.Version() {
+			sendErrorMessage(conn, "The takeback request is out of date")
+			return
+		}
+
+		if !undoConfirm.Confirm {
+			BroadcastUndoRejected(gameID, undoConfirm.PlayerID)
+			return
+		}
+
+		if err := game.UndoMove(); err != nil {
+			sendErrorMessage(conn, err.Error())
+			return
+		}
+		if err := SaveGame(game); err != nil {
+			log.Printf("Error saving game after undo: %v", err)
+			sendErrorMessage(conn, "Failed to undo move")
+			return
+		}
+		ScheduleClock(game)
+		log.Printf("Game %s took back a move after confirmation from %s", gameID, undoConfirm.PlayerID)
+		BroadcastGameState(gameID, game)
+	case TypeResign, TypeOfferDraw, TypeAcceptDraw, TypeDeclineDraw:
+		var request struct {
+			PlayerID string `json:"playerId"`
+		}
+		if err := json.Unmarshal(message.Payload, &request); err != nil {
+			log.Printf("Error unmarshaling %s request: %v", message.Type, err)
+			return
+		}
+
+		var err error
+		switch message.Type {
+		case TypeResign:
+			err = game.Resign(request.PlayerID)
+		case TypeOfferDraw:
+			err = game.OfferDraw(request.PlayerID)
+		case TypeAcceptDraw:
+			err = game.AcceptDraw(request.PlayerID)
+		case TypeDeclineDraw:
+			err = game.DeclineDraw(request.PlayerID)
+		}
+		if err != nil {
+			sendErrorMessage(conn, err.Error())
+			return
+		}
+		log.Printf("Player %s sent %s in game %s", request.PlayerID, message.Type, gameID)
+		SaveAndBroadcast(game)
+	case TypeHint:
+		var hintRequest struct {
+			PlayerID string `json:"playerId"`
+		}
+		if err := json.Unmarshal(message.Payload, &hintRequest); err != nil {
+			log.Printf("Error unmarshaling hint request: %v", err)
+			return
+		}
 
+		// The search takes a while, so it runs without holding the game's lock
+		go func() {
+			analysis, err := AnalyzeGame(game, hintRequest.PlayerID)
+			if err != nil {
+				sendMessage(conn, TypeError, ErrorMessage{Error: err.Error()})
+				return
+			}
+			sendMessage(conn, TypeAnalysis, analysis)
+		}()
+	}
+}
 
+// SaveAndBroadcast saves a game after a server side change, counts the
+// result if it ended the game and sends the new state to everyone.
+// The caller must hold the game's lock.
+func SaveAndBroadcast(game *games.Game) {
+	if err := SaveGame(game); err != nil {
+		log.Printf("Error saving game %s: %v", game.ID, err)
+	}
+	ScheduleClock(game)
+	if game.Status == games.StatusFinished {
+		UpdatePlayerStats(game)
+	}
+	BroadcastGameState(game.ID, game)
+	if game.Status == games.StatusFinished {
+		finishExhibition(game)
+	}
 }
 func BroadcastResetGame(gameID string){
 	log.Printf("Broadcasting reset game for game: %s", gameID)
-	connMutex.Lock()
-	conns := connections[gameID]
-	defer connMutex.Unlock()
 
 	// Create reset game message
 	message := Message{
@@ -383,19 +559,24 @@ func BroadcastResetGame(gameID string){
 	messageJSON, _ := json.Marshal(message)
 
 	// Send to all connections
-	for _, conn := range conns {
+	connMutex.Lock()
+	var failed []*websocket.Conn
+	for _, conn := range connections[gameID] {
 		if err := conn.WriteMessage(websocket.TextMessage, messageJSON); err != nil {
 			log.Printf("Error sending reset game: %v", err)
-			conn.Close()
-			RemoveGameConnection(gameID, conn)
+			failed = append(failed, conn)
 		}
 	}
+	connMutex.Unlock()
+	dropGameConnections(gameID, failed)
 }
 func BroadcastResetRequest(gameID string, otherPlayerID string, requestingPlayerID string) {
     log.Printf("Broadcasting reset request for game: %s", gameID)
-    connMutex.Lock()
-    conn := playerConnections[otherPlayerID]
-    defer connMutex.Unlock()
+    conn := GetPlayerConnection(otherPlayerID)
+    if conn == nil {
+        log.Printf("No connection for player %s in game %s", otherPlayerID, gameID)
+        return
+    }
 
     // Create reset request message
     resetRequestData := struct {
@@ -413,19 +594,15 @@ func BroadcastResetRequest(gameID string, otherPlayerID string, requestingPlayer
     messageJSON, _ := json.Marshal(message)
     
     // Send to all connections
-	if err := conn.WriteMessage(websocket.TextMessage, messageJSON); err != nil {
+	if err := writeMessage(conn, messageJSON); err != nil {
 		log.Printf("Error sending reset request: %v", err)
-		conn.Close()
-		RemoveGameConnection(gameID, conn)
+		dropGameConnections(gameID, []*websocket.Conn{conn})
 	}
 }
 
 // Function to broadcast reset rejection
 func BroadcastResetRejected(gameID string, rejectingPlayerID string) {
     log.Printf("Broadcasting reset rejection for game: %s", gameID)
-    connMutex.Lock()
-    conns := connections[gameID]
-    defer connMutex.Unlock()
 
     // Create reset rejected message
     resetRejectedData := struct {
@@ -443,21 +620,97 @@ func BroadcastResetRejected(gameID string, rejectingPlayerID string) {
     messageJSON, _ := json.Marshal(message)
     
     // Send to all connections
-    for _, conn := range conns {
+    connMutex.Lock()
+    var failed []*websocket.Conn
+    for _, conn := range connections[gameID] {
         if err := conn.WriteMessage(websocket.TextMessage, messageJSON); err != nil {
             log.Printf("Error sending reset rejection: %v", err)
-            conn.Close()
-            RemoveGameConnection(gameID, conn)
+            failed = append(failed, conn)
         }
     }
+    connMutex.Unlock()
+    dropGameConnections(gameID, failed)
+}
+
+// BroadcastUndoRequest asks the other player to accept a takeback
+func BroadcastUndoRequest(gameID string, otherPlayerID string, requestingPlayerID string) {
+	log.Printf("Broadcasting undo request for game: %s", gameID)
+	conn := GetPlayerConnection(otherPlayerID)
+	if conn == nil {
+		log.Printf("No connection for player %s in game %s", otherPlayerID, gameID)
+		return
+	}
+
+	undoRequestData := struct {
+		RequestingPlayerID string `json:"requestingPlayerId"`
+	}{
+		RequestingPlayerID: requestingPlayerID,
+	}
+
+	payload, _ := json.Marshal(undoRequestData)
+	message := Message{
+		Type:    "requestUndo",
+		Payload: payload,
+	}
+
+	messageJSON, _ := json.Marshal(message)
+	if err := writeMessage(conn, messageJSON); err != nil {
+		log.Printf("Error sending undo request: %v", err)
+	}
 }
 
-func updatePlayerStats(game *games.Game ){
+// BroadcastUndoRejected tells everyone in the game that a takeback was rejected
+func BroadcastUndoRejected(gameID string, rejectingPlayerID string) {
+	log.Printf("Broadcasting undo rejection for game: %s", gameID)
 
-	if game.Player1ID == "bot" || game.Player2ID == "bot"{
+	undoRejectedData := struct {
+		RejectingPlayerID string `json:"rejectingPlayerId"`
+	}{
+		RejectingPlayerID: rejectingPlayerID,
+	}
+
+	payload, _ := json.Marshal(undoRejectedData)
+	message := Message{
+		Type:    "undoRejected",
+		Payload: payload,
+	}
+
+	messageJSON, _ := json.Marshal(message)
+	connMutex.Lock()
+	var failed []*websocket.Conn
+	for _, conn := range connections[gameID] {
+		if err := conn.WriteMessage(websocket.TextMessage, messageJSON); err != nil {
+			log.Printf("Error sending undo rejection: %v", err)
+			failed = append(failed, conn)
+		}
+	}
+	connMutex.Unlock()
+	dropGameConnections(gameID, failed)
+}
+
+// UpdatePlayerStats records the result of a finished game on both players
+func UpdatePlayerStats(game *games.Game ){
+
+	if !game.Rated() {
 		return 
 	}
 
+	// Only games that were played out count, aborted or unfinished games don't
+	if game.Status != games.StatusFinished {
+		return
+	}
+
+	if game.Outcome == games.OutcomeDraw {
+		for _, playerID := range []string{game.Player1ID, game.Player2ID} {
+			player, err := GetPlayer(playerID)
+			if err == nil {
+				player.Draws++
+				SavePlayer(player)
+			}
+		}
+		return
+	}
+
 	if game.WinnerID != ""{
 		player , err := GetPlayer(game.WinnerID)
 		if err == nil {
@@ -503,7 +756,7 @@ func HandleGlobalConnection(conn *websocket.Conn) {
     }
     
     welcomeJSON, _ := json.Marshal(welcomeMsg)
-    if err := conn.WriteMessage(websocket.TextMessage, welcomeJSON); err != nil {
+    if err := writeMessage(conn, welcomeJSON); err != nil {
         log.Printf("Error sending welcome message: %v", err)
         return
     }
@@ -520,7 +773,7 @@ func HandleGlobalConnection(conn *websocket.Conn) {
     
     go func() {
         for range ticker.C {
-            if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+            if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
                 return
             }
         }
@@ -544,22 +797,42 @@ func HandleGlobalConnection(conn *websocket.Conn) {
         case TypeJoinGame:
             log.Printf("Received join request")
             var joinRequest struct {
-                PlayerID string `json:"playerId"`
+                PlayerID    string             `json:"playerId"`
+                Rules       games.Rules        `json:"rules"`
+                Variant     games.Variant      `json:"variant,omitempty"`
+                TimeControl *games.TimeControl `json:"timeControl,omitempty"`
             }
             if err := json.Unmarshal(message.Payload, &joinRequest); err != nil {
                 log.Printf("Error unmarshaling join request: %v", err)
                 continue
             }
+            if joinRequest.Variant != "" {
+                joinRequest.Rules.Variant = joinRequest.Variant
+            }
+            rules := joinRequest.Rules.WithDefaults()
+            if err := rules.Validate(); err != nil {
+                sendErrorMessage(conn, "Invalid rules: "+err.Error())
+                continue
+            }
+            if joinRequest.TimeControl != nil {
+                if err := joinRequest.TimeControl.Validate(); err != nil {
+                    sendErrorMessage(conn, "Invalid time control: "+err.Error())
+                    continue
+                }
+            }
             RegisterPlayerConnection(joinRequest.PlayerID, conn)
             // Try to find a waiting game
-            waitingGame, err := FindWaitingGame()
+            waitingGame, err := FindWaitingGame(rules, joinRequest.TimeControl)
             
             if err == nil && waitingGame != nil {
                 // Found a waiting game, join it
                 log.Printf("Joining waiting game %s for playerId: %s", waitingGame.ID, joinRequest.PlayerID)
 
+                waitingGame.Lock()
                 waitingGame.Player2ID = joinRequest.PlayerID
-                waitingGame.Status = games.StatusActive
+                waitingGame.Start()
+                ScheduleClock(waitingGame)
+                waitingGame.Unlock()
                 
                 if err := SaveGame(waitingGame); err != nil {
                     log.Printf("Error saving game after join: %v", err)
@@ -583,7 +856,8 @@ func HandleGlobalConnection(conn *websocket.Conn) {
                 log.Printf("Creating new game for %s", joinRequest.PlayerID)
                 
                 // No waiting game found, create a new one
-                newGame := games.NewGame(games.OnlineMultiplayer, joinRequest.PlayerID, "")
+                newGame := games.NewGame(games.OnlineMultiplayer, joinRequest.PlayerID, "", rules)
+                newGame.SetTimeControl(joinRequest.TimeControl)
                 
                 if err := SaveGame(newGame); err != nil {
                     log.Printf("Error creating new game: %v", err)
@@ -617,7 +891,7 @@ func sendGameCreatedMessage(conn *websocket.Conn, game *games.Game) {
     }
     
     messageJSON, _ := json.Marshal(message)
-    if err := conn.WriteMessage(websocket.TextMessage, messageJSON); err != nil {
+    if err := writeMessage(conn, messageJSON); err != nil {
         log.Printf("Error sending game created message: %v", err)
     }
 }
@@ -655,35 +929,77 @@ func sendGameStartMessage(conn *websocket.Conn, game *games.Game) {
     }
     
     // Send the message to the connection
-    if err := conn.WriteMessage(websocket.TextMessage, messageJSON); err != nil {
+    if err := writeMessage(conn, messageJSON); err != nil {
         log.Printf("Error sending game start message: %v", err)
     } else {
         log.Printf("Sent gameStart message to client for game %s", game.ID)
     }
 }
 
+// broadcastMessage sends a message to every connection of a game
+func broadcastMessage(gameID string, messageType MessageType, payload interface{}) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error marshaling %s payload: %v", messageType, err)
+		return
+	}
+	messageJSON, _ := json.Marshal(Message{Type: messageType, Payload: payloadJSON})
+
+	connMutex.Lock()
+	defer connMutex.Unlock()
+	for _, conn := range connections[gameID] {
+		if err := conn.WriteMessage(websocket.TextMessage, messageJSON); err != nil {
+			log.Printf("Error sending %s message: %v", messageType, err)
+		}
+	}
+}
+
+// sendMessage sends a message to one connection. It takes the connection lock,
+// so it can be used outside the connection's read loop.
+func sendMessage(conn *websocket.Conn, messageType MessageType, payload interface{}) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error marshaling %s payload: %v", messageType, err)
+		return
+	}
+	messageJSON, _ := json.Marshal(Message{Type: messageType, Payload: payloadJSON})
+
+	if err := writeMessage(conn, messageJSON); err != nil {
+		log.Printf("Error sending %s message: %v", messageType, err)
+	}
+}
+
+// writeMessage sends an encoded message to one connection. Every write to a
+// connection takes connMutex, a websocket allows only one writer at a time.
+func writeMessage(conn *websocket.Conn, messageJSON []byte) error {
+	connMutex.Lock()
+	defer connMutex.Unlock()
+	return conn.WriteMessage(websocket.TextMessage, messageJSON)
+}
+
 // Helper function to send error messages
 func sendErrorMessage(conn *websocket.Conn, errorText string) {
-    errMsg := ErrorMessage{Error: errorText}
-    errJSON, _ := json.Marshal(errMsg)
-    response := Message{
-        Type:    TypeError,
-        Payload: errJSON,
-    }
-    responseJSON, _ := json.Marshal(response)
-    conn.WriteMessage(websocket.TextMessage, responseJSON)
+    sendMessage(conn, TypeError, ErrorMessage{Error: errorText})
 }
 
-// Add this function to find a waiting game
-func FindWaitingGame() (*games.Game, error) {
+// Add this function to find a waiting game with the same rules and time control
+func FindWaitingGame(rules games.Rules, tc *games.TimeControl) (*games.Game, error) {
 	gameMutex.RLock()
 	defer gameMutex.RUnlock()
 	
 	for _, game := range gamesMap {
-		if game.Status == games.StatusWaiting {
+		if game.Status == games.StatusWaiting && game.Rules == rules && SameTimeControl(game, tc) {
 			return game, nil
 		}
 	}
 	
 	return nil, errors.New("no waiting game found")
-}
\ No newline at end of file
+}
+
+// SameTimeControl reports whether a game is played with the time control, nil means untimed
+func SameTimeControl(game *games.Game, tc *games.TimeControl) bool {
+	if game.Clock == nil || tc == nil {
+		return game.Clock == nil && tc == nil
+	}
+	return game.Clock.TimeControl == *tc
+}
@@ -120,7 +120,7 @@ func ListPlayers() ([]*games.Player, error) {
 	return result, nil
 }
 
-// GetLeaderboard returns players sorted by win count
+// GetLeaderboard returns players sorted by win count, ties go to the player with fewer losses, then more draws
 func GetLeaderboard(limit int) ([]*games.Player, error) {
 	players, err := ListPlayers()
 	if err != nil {
@@ -131,7 +131,7 @@ func GetLeaderboard(limit int) ([]*games.Player, error) {
 	// In a real database, this would be done with a query
 	for i := 0; i < len(players); i++ {
 		for j := i + 1; j < len(players); j++ {
-			if players[j].Wins > players[i].Wins {
+			if rankedHigher(players[j], players[i]) {
 				players[i], players[j] = players[j], players[i]
 			}
 		}
@@ -143,4 +143,15 @@ func GetLeaderboard(limit int) ([]*games.Player, error) {
 	}
 	
 	return players, nil
-}
\ No newline at end of file
+}
+
+// rankedHigher reports whether a comes before b on the leaderboard
+func rankedHigher(a, b *games.Player) bool {
+	if a.Wins != b.Wins {
+		return a.Wins > b.Wins
+	}
+	if a.Losses != b.Losses {
+		return a.Losses < b.Losses
+	}
+	return a.Draws > b.Draws
+}
@@ -0,0 +1,200 @@
+package db
+
+import (
+	"connect4/games"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Exhibition states
+const (
+	ExhibitionPaused   = "paused"   // Created or paused, the bots wait
+	ExhibitionRunning  = "running"  // The bots move one after the other, DelayMs apart
+	ExhibitionStopped  = "stopped"  // Called off, the game is abandoned
+	ExhibitionFinished = "finished" // The game was played to the end
+)
+
+// TypeExhibition tells watchers that an exhibition was started, paused or stopped
+const TypeExhibition MessageType = "exhibition"
+
+// Exhibition is a game between two bots that the server plays out
+type Exhibition struct {
+	GameID  string `json:"gameId"`
+	State   string `json:"state"`
+	DelayMs int64  `json:"delayMs"` // Pause between one bot's move and the next bot starting to think
+
+	timer *time.Timer // Pending next move
+}
+
+// exhibitions, game id -> exhibition
+var (
+	exhibitions     = make(map[string]*Exhibition)
+	exhibitionMutex = &sync.Mutex{}
+)
+
+// CreateExhibition registers a game between two bots, it starts paused
+func CreateExhibition(game *games.Game, delay time.Duration) (Exhibition, error) {
+	if game.BotFor(games.RedToken) == nil || game.BotFor(games.YellowToken) == nil {
+		return Exhibition{}, errors.New("both players of an exhibition must be bots")
+	}
+
+	exhibitionMutex.Lock()
+	defer exhibitionMutex.Unlock()
+	exhibition := &Exhibition{GameID: game.ID, State: ExhibitionPaused, DelayMs: delay.Milliseconds()}
+	exhibitions[game.ID] = exhibition
+	return *exhibition, nil
+}
+
+// GetExhibition returns the exhibition of a game
+func GetExhibition(gameID string) (Exhibition, error) {
+	exhibitionMutex.Lock()
+	defer exhibitionMutex.Unlock()
+	exhibition, ok := exhibitions[gameID]
+	if !ok {
+		return Exhibition{}, errors.New("exhibition not found")
+	}
+	return *exhibition, nil
+}
+
+// StartExhibition lets the bots play, a delay of 0 or more also changes the
+// delay between moves. Starting a running exhibition only changes the delay.
+func StartExhibition(gameID string, delay time.Duration) (Exhibition, error) {
+	exhibitionMutex.Lock()
+	defer exhibitionMutex.Unlock()
+	exhibition, ok := exhibitions[gameID]
+	if !ok {
+		return Exhibition{}, errors.New("exhibition not found")
+	}
+	if exhibition.State == ExhibitionStopped || exhibition.State == ExhibitionFinished {
+		return Exhibition{}, errors.New("exhibition is over")
+	}
+	if delay >= 0 {
+		exhibition.DelayMs = delay.Milliseconds()
+	}
+	if exhibition.State != ExhibitionRunning {
+		exhibition.State = ExhibitionRunning
+		scheduleExhibitionMove(exhibition, 0)
+	}
+	broadcastMessage(gameID, TypeExhibition, exhibition)
+	return *exhibition, nil
+}
+
+// PauseExhibition stops the bots after the move they are thinking about
+func PauseExhibition(gameID string) (Exhibition, error) {
+	exhibitionMutex.Lock()
+	defer exhibitionMutex.Unlock()
+	exhibition, ok := exhibitions[gameID]
+	if !ok {
+		return Exhibition{}, errors.New("exhibition not found")
+	}
+	if exhibition.State != ExhibitionRunning {
+		return Exhibition{}, errors.New("exhibition is not running")
+	}
+	exhibition.State = ExhibitionPaused
+	exhibition.stopTimer()
+	broadcastMessage(gameID, TypeExhibition, exhibition)
+	return *exhibition, nil
+}
+
+// StopExhibition calls the exhibition off and abandons its game. The move
+// a bot is thinking about is thrown away.
+func StopExhibition(gameID string) (Exhibition, error) {
+	game, err := GetGame(gameID)
+	if err != nil {
+		return Exhibition{}, err
+	}
+	game.Lock()
+	defer game.Unlock()
+
+	exhibitionMutex.Lock()
+	exhibition, ok := exhibitions[gameID]
+	if !ok {
+		exhibitionMutex.Unlock()
+		return Exhibition{}, errors.New("exhibition not found")
+	}
+	if exhibition.State == ExhibitionStopped || exhibition.State == ExhibitionFinished {
+		exhibitionMutex.Unlock()
+		return Exhibition{}, errors.New("exhibition is over")
+	}
+	exhibition.State = ExhibitionStopped
+	exhibition.stopTimer()
+	stopped := *exhibition
+	exhibitionMutex.Unlock()
+
+	game.Abandon()
+	SaveAndBroadcast(game)
+	broadcastMessage(gameID, TypeExhibition, stopped)
+	return stopped, nil
+}
+
+// continueExhibition schedules the next move of a running exhibition after
+// a bot moved. Games that aren't exhibitions or are over are left alone.
+// The caller must hold the game's lock.
+func continueExhibition(game *games.Game) {
+	if game.Status != games.StatusActive {
+		return
+	}
+	exhibitionMutex.Lock()
+	defer exhibitionMutex.Unlock()
+	exhibition, ok := exhibitions[game.ID]
+	if ok && exhibition.State == ExhibitionRunning {
+		scheduleExhibitionMove(exhibition, time.Duration(exhibition.DelayMs)*time.Millisecond)
+	}
+}
+
+// isExhibition reports whether the game is played as an exhibition
+func isExhibition(gameID string) bool {
+	exhibitionMutex.Lock()
+	defer exhibitionMutex.Unlock()
+	_, ok := exhibitions[gameID]
+	return ok
+}
+
+// finishExhibition marks the exhibition of a finished game finished, however
+// the game ended: by a bot's move, on time or otherwise. A stopped exhibition
+// stays stopped. The caller must hold the game's lock.
+func finishExhibition(game *games.Game) {
+	exhibitionMutex.Lock()
+	defer exhibitionMutex.Unlock()
+	exhibition, ok := exhibitions[game.ID]
+	if !ok || exhibition.State == ExhibitionStopped || exhibition.State == ExhibitionFinished {
+		return
+	}
+	exhibition.State = ExhibitionFinished
+	exhibition.stopTimer()
+	broadcastMessage(game.ID, TypeExhibition, exhibition)
+}
+
+// scheduleExhibitionMove starts the next bot on its move after the delay.
+// The caller must hold exhibitionMutex.
+func scheduleExhibitionMove(exhibition *Exhibition, delay time.Duration) {
+	exhibition.stopTimer()
+	gameID := exhibition.GameID
+	exhibition.timer = time.AfterFunc(delay, func() {
+		game, err := GetGame(gameID)
+		if err != nil {
+			log.Printf("Exhibition game %s is gone: %v", gameID, err)
+			return
+		}
+		game.Lock()
+		defer game.Unlock()
+
+		// It may have been paused or stopped while the timer was pending
+		exhibitionMutex.Lock()
+		running := exhibition.State == ExhibitionRunning
+		exhibitionMutex.Unlock()
+		if running {
+			ScheduleBotMove(game)
+		}
+	})
+}
+
+// stopTimer cancels a pending move. The caller must hold exhibitionMutex.
+func (e *Exhibition) stopTimer() {
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+}
@@ -0,0 +1,61 @@
+package games
+
+import "errors"
+
+// AnalysisSettings are the search limits used for hints and analysis
+var AnalysisSettings = LevelSettings{Depth: 12, EndgameDepth: 16, TimeLimitMs: 1000}
+
+// Analysis is the bot's view of a position for the player to move
+type Analysis struct {
+	Token     int         `json:"token"`     // Player to move, the scores are theirs
+	Moves     []MoveScore `json:"moves"`     // Score of every legal move
+	Best      Move        `json:"best"`      // Recommended move
+	Line      []Move      `json:"line"`      // Best line of play for both players, starting with Best
+	Wins      []Move      `json:"wins"`      // Moves that win at once, drops and pops
+	MustBlock []Move      `json:"mustBlock"` // Moves the opponent would win with on their next move
+}
+
+// AnalyzePosition searches the position for the token to move
+func AnalyzePosition(board Position, token int) (Analysis, error) {
+	if board.HasWon(RedToken) || board.HasWon(YellowToken) || !board.HasLegalMove(token) {
+		return Analysis{}, errors.New("the game is over")
+	}
+
+	bot := NewBotPlayer("", token)
+	bot.Settings = AnalysisSettings
+	moves, line := bot.ScoreMoves(board)
+
+	analysis := Analysis{
+		Token:     token,
+		Moves:     moves,
+		Line:      line,
+		Wins:      []Move{},
+		MustBlock: []Move{},
+	}
+	
+	// The best line starts with the best move, unless not even one iteration finished
+	if len(line) > 0 {
+		analysis.Best = line[0]
+	} else if len(moves) > 0 {
+		analysis.Best = moves[0].Move
+	}
+
+	analysis.Wins = winningMoves(board, token)
+	analysis.MustBlock = winningMoves(board, otherToken(token))
+	return analysis, nil
+}
+
+// winningMoves returns the moves that win at once for the token, as if it
+// were the token's turn. A pop that completes lines for both players wins
+// for the player who popped.
+func winningMoves(board Position, token int) []Move {
+	wins := []Move{}
+	for _, action := range boardActions(board, token) {
+		boardCopy := board
+		applyAction(&boardCopy, action, token)
+		if moveWinner(boardCopy, token) == token {
+			wins = append(wins, actionToMove(board, action))
+		}
+	}
+	return wins
+}
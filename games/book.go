@@ -0,0 +1,156 @@
+package games
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"os"
+	"sync"
+)
+
+// Book is an opening book: the scored moves of positions near the start of
+// the game, found by deep searches ahead of time. It is stored as JSON.
+//
+// Positions are keyed by their board snapshot with the player to move, see
+// FormatBoard, so move orders that lead to the same position share an entry.
+type Book struct {
+	Rules     Rules                 `json:"rules"`
+	Depth     int                   `json:"depth"` // Plies from the empty board the book covers
+	Positions map[string][]BookMove `json:"positions"`
+
+	// Margin lets the bot pick any move scoring at most this much below the
+	// best one, closer moves are more likely. 0 always plays the best move.
+	Margin int `json:"-"`
+}
+
+// BookMove is a move of a book position and its score for the player making it
+type BookMove struct {
+	Column int      `json:"column"`
+	Kind   MoveKind `json:"kind,omitempty"` // MoveDrop if empty
+	Score  int      `json:"score"`
+}
+
+// NewBook creates an empty book for the rules
+func NewBook(rules Rules, depth int) *Book {
+	return &Book{
+		Rules:     rules,
+		Depth:     depth,
+		Positions: make(map[string][]BookMove),
+	}
+}
+
+// LoadBook reads a book file
+func LoadBook(path string) (*Book, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	book := &Book{}
+	if err := json.Unmarshal(data, book); err != nil {
+		return nil, err
+	}
+	book.Rules = book.Rules.WithDefaults()
+	if err := book.Rules.Validate(); err != nil {
+		return nil, err
+	}
+	if book.Positions == nil {
+		return nil, errors.New("book has no positions")
+	}
+	return book, nil
+}
+
+// Save writes the book to a file
+func (b *Book) Save(path string) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Add stores the moves of a position
+func (b *Book) Add(pos Position, tokenToMove int, moves []BookMove) {
+	b.Positions[FormatBoard(pos, tokenToMove)] = moves
+}
+
+// Lookup returns the moves stored for a position
+func (b *Book) Lookup(pos Position, tokenToMove int) ([]BookMove, bool) {
+	moves, ok := b.Positions[FormatBoard(pos, tokenToMove)]
+	return moves, ok && len(moves) > 0
+}
+
+// Choose picks a book move for the position, see Margin.
+// It reports false if the position isn't in the book.
+func (b *Book) Choose(pos Position, tokenToMove int) (BookMove, bool) {
+	moves, ok := b.Lookup(pos, tokenToMove)
+	if !ok {
+		return BookMove{}, false
+	}
+
+	best := moves[0]
+	for _, move := range moves {
+		if move.Score > best.Score {
+			best = move
+		}
+	}
+	if b.Margin <= 0 || isDecisive(best.Score) {
+		return best, true
+	}
+
+	// Weigh every move within the margin by how close it is to the best
+	total := 0
+	weights := make([]int, len(moves))
+	for i, move := range moves {
+		if best.Score-move.Score <= b.Margin {
+			weights[i] = b.Margin - (best.Score - move.Score) + 1
+			total += weights[i]
+		}
+	}
+	pick := rand.Intn(total)
+	for i, weight := range weights {
+		if pick < weight {
+			return moves[i], true
+		}
+		pick -= weight
+	}
+	return best, true
+}
+
+// the book loaded at start up, used by every bot whose game has the book's rules
+var (
+	openingBook *Book
+	bookMutex   = &sync.RWMutex{}
+)
+
+// UseBook makes the bots consult the book, nil turns the book off
+func UseBook(book *Book) {
+	bookMutex.Lock()
+	defer bookMutex.Unlock()
+	openingBook = book
+}
+
+// bookAction returns the book's action for the bot in a position, -1 if it has none
+func (bot *BotPlayer) bookAction(board Position) int {
+	bookMutex.RLock()
+	book := openingBook
+	bookMutex.RUnlock()
+	if book == nil || book.Rules != board.Rules() {
+		return -1
+	}
+
+	move, ok := book.Choose(board, bot.PlayerToken)
+	if !ok {
+		return -1
+	}
+	action := move.Column
+	if move.Kind == MovePop {
+		action += board.Width()
+	}
+
+	// A stale or hand edited book mustn't make the bot play an illegal move
+	boardCopy := board
+	if action < 0 || action >= bot.actionCount(board) || !bot.playAction(&boardCopy, action, bot.PlayerToken) {
+		return -1
+	}
+	return action
+}
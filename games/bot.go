@@ -2,6 +2,10 @@ package games
 
 import (
 	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,18 +15,56 @@ const (
 	ThreeInRow  = 1000     // Score for three in a row
 	TwoInRow    = 10       // Score for two in a row
 	OneInRow    = 1        // Score for a single piece
-	MaxDepth    = 7        // Maximum depth for minimax
-	TimeLimit   = 980      // Time limit in milliseconds
+	MaxDepth    = 7        // Maximum depth for minimax at the default level
+	TimeLimit   = 980      // Time limit in milliseconds at the default level
 )
 
-// BotPlayer implements an optimized minimax bot with alpha-beta pruning and dynamic programming
+// DefaultBotWorkers is the number of goroutines a bot searches with unless
+// its Workers is set. The main function can change it at start up.
+// BenchmarkLazySMP measures the depth the extra workers gain.
+var DefaultBotWorkers = min(runtime.NumCPU(), 4)
+
+// BotPlayer implements an optimized minimax bot with alpha-beta pruning and dynamic programming.
+// It searches with several goroutines that share its table, see deepen. A
+// BotPlayer plays one game, it must not choose two moves at the same time.
 type BotPlayer struct {
 	PlayerID     string
 	PlayerToken  int
 	OpponentToken int
-	TransTable   map[string]int   // Transposition table for dynamic programming
-	NodesExplored int             // For statistics
-	StartTime    time.Time        // For time management
+	TransTable   *TransTable `json:"-"` // Transposition table for dynamic programming, created on first use
+	KeepTable    bool         // Keep the table from one move to the next in the same game
+	Workers      int          // Goroutines searching in parallel, 0 for DefaultBotWorkers
+	NodesExplored int             // For statistics, of the last search by all workers
+	TableProbes  int              // Table lookups of the last search by all workers
+	TableHits    int              // Lookups that found the position
+	Depth        int              // Deepest iteration the last search finished
+	Score        int              // Score of the last search's best move, positive is good for the bot
+	StartTime    time.Time        // Start of the last search
+	Level        BotLevel         // Difficulty, see SetLevel
+	Settings     LevelSettings    // Search limits of the level
+	Profile      EvalProfile      // Evaluation weights, see SetProfile
+	
+	pv   []int      // Best line of the last search, as actions
+	rng  *rand.Rand // For the level's mistakes and noise, see Seed
+	last SearchInfo // How the last move was found, see LastSearch
+}
+
+// search is the state of one worker of a search. Everything a worker
+// changes while searching is in here, apart from the shared table.
+type search struct {
+	bot     *BotPlayer
+	start   time.Time
+	limitMs int64        // Time limit, the level's unless the budget is lower
+	stop    *atomic.Bool // Set once the main worker is done, the helpers stop with it
+
+	nodes    int
+	probes   int   // Table lookups
+	hits     int   // Table lookups that found the position
+	timedOut bool  // Set when the search hit the time limit or was stopped
+	pv       []int // Best line of the last finished iteration, as actions
+	depth    int   // Depth of the last finished iteration
+	best     int   // Best action of the last finished iteration
+	score    int   // Score of the best action
 }
 
 // NewBotPlayer creates a new bot player
@@ -32,223 +74,602 @@ func NewBotPlayer(playerID string, playerToken int) *BotPlayer {
 		opponentToken = YellowToken
 	}
 	
+	profile, _ := GetProfile(DefaultProfile)
+	
 	return &BotPlayer{
 		PlayerID:     playerID,
 		PlayerToken:  playerToken,
 		OpponentToken: opponentToken,
-		TransTable:   make(map[string]int),
+		KeepTable:    true,
+		Level:        DefaultBotLevel,
+		Settings:     botLevels[DefaultBotLevel],
+		Profile:      profile,
+		rng:          rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 }
 
-// GetNextMove returns the best move for the bot
-func (bot *BotPlayer) GetNextMove(game *Game) int {
+// Seed makes the bot's mistakes and noise repeatable
+func (bot *BotPlayer) Seed(seed int64) {
+	bot.rng = rand.New(rand.NewSource(seed))
+}
+
+// GetNextMove returns the bot's move in the game, see ChooseMove
+func (bot *BotPlayer) GetNextMove(game *Game) Move {
+	move := bot.ChooseMove(game.Board, bot.PlayerToken, Budget{})
+	move.PlayerID = bot.PlayerID
+	return move
+}
+
+// Release drops the bot's table once its game is over, the next search
+// creates a new one
+func (bot *BotPlayer) Release() {
+	bot.TransTable = nil
+	bot.pv = nil
+}
+
+// ChooseMove returns the best move for the token, this makes the bot the
+// "minimax" strategy. It searches one ply deeper at a time and plays the best
+// move of the deepest search that finished within the time limit, a search
+// cut short by the clock is thrown away.
+func (bot *BotPlayer) ChooseMove(board Position, token int, budget Budget) Move {
+	// The table's scores are from the bot's side, they are no use for the other color
+	if token != bot.PlayerToken {
+		bot.PlayerToken = token
+		bot.OpponentToken = otherToken(token)
+		if bot.TransTable != nil {
+			bot.TransTable.Clear()
+		}
+	}
+	bot.startSearch()
+	limitMs := bot.Settings.TimeLimitMs
+	if budget.TimeLimit > 0 && budget.TimeLimit.Milliseconds() < limitMs {
+		limitMs = budget.TimeLimit.Milliseconds()
+	}
+	
+	// Weaker levels sometimes play a random move on purpose
+	if bot.Settings.MistakeRate > 0 && bot.rng.Float64() < bot.Settings.MistakeRate {
+		if action := bot.randomAction(board); action != -1 {
+			return bot.played(board, action, SourceMistake)
+		}
+	}
+	
+	// Known openings are played from the book without searching
+	if action := bot.bookAction(board); action != -1 {
+		return bot.played(board, action, SourceBook)
+	}
+	
+	// The perfect level plays the solver's move when it is found in time
+	if bot.Settings.Solve {
+		if action := bot.solvedAction(board, limitMs); action != -1 {
+			return bot.played(board, action, SourceSolver)
+		}
+	}
+	
+	// The noise of each move stays the same through all depths
+	noise := make([]int, bot.actionCount(board))
+	for i := range noise {
+		noise[i] = bot.noise()
+	}
+	
+	return bot.played(board, bot.deepen(board, noise, nil, limitMs), SourceSearch)
+}
+
+// played records how the move was found, see LastSearch, and returns it
+func (bot *BotPlayer) played(board Position, action int, source string) Move {
+	elapsed := time.Since(bot.StartTime)
+	info := SearchInfo{
+		Source:    source,
+		Profile:   bot.Profile.Name,
+		Depth:     bot.Depth,
+		Nodes:     bot.NodesExplored,
+		ElapsedMs: elapsed.Milliseconds(),
+		Score:     bot.Score,
+	}
+	if elapsed > 0 {
+		info.NPS = int(float64(bot.NodesExplored) / elapsed.Seconds())
+	}
+	if bot.TableProbes > 0 {
+		info.TTHitRate = float64(bot.TableHits) / float64(bot.TableProbes)
+	}
+
+	// The line only belongs to the move if the search chose it
+	line := bot.pv
+	if len(line) == 0 || line[0] != action {
+		line = []int{action}
+	}
+	for _, pvAction := range line {
+		info.PV = append(info.PV, bot.strategyMove(board, pvAction))
+	}
+	bot.last = info
+	return bot.strategyMove(board, action)
+}
+
+// LastSearch tells how the bot found its last move
+func (bot *BotPlayer) LastSearch() SearchInfo {
+	return bot.last
+}
+
+// strategyMove converts a search action into a move without a player
+func (bot *BotPlayer) strategyMove(board Position, action int) Move {
+	move := bot.actionMove(board, action)
+	move.PlayerID = ""
+	return move
+}
+
+// ScoreMoves searches the position for the bot like GetNextMove, but without
+// the level's noise, mistakes or solver. It returns the score of every legal
+// move from the deepest finished iteration and the best line of play.
+func (bot *BotPlayer) ScoreMoves(board Position) ([]MoveScore, []Move) {
+	bot.startSearch()
+	
+	scores := make([]int, bot.actionCount(board))
+	bot.deepen(board, make([]int, len(scores)), scores, bot.Settings.TimeLimitMs)
+	
+	moves := []MoveScore{}
+	for _, action := range bot.legalActions(board) {
+		moves = append(moves, MoveScore{Move: bot.actionMove(board, action), Score: scores[action]})
+	}
+	
+	// The line alternates between both players, so its moves have no player
+	line := []Move{}
+	for _, action := range bot.pv {
+		line = append(line, bot.strategyMove(board, action))
+	}
+	return moves, line
+}
+
+// MoveScore is the search score of a move, positive is good for the bot
+type MoveScore struct {
+	Move  Move `json:"move"`
+	Score int  `json:"score"`
+}
+
+// startSearch resets the counters and the table for a new search
+func (bot *BotPlayer) startSearch() {
 	bot.StartTime = time.Now()
 	bot.NodesExplored = 0
-	bot.TransTable = make(map[string]int)
+	bot.TableProbes = 0
+	bot.TableHits = 0
+	bot.Depth = 0
+	bot.Score = 0
+	bot.pv = nil
+	
+	if bot.TransTable == nil {
+		bot.TransTable = NewTransTable(DefaultTableBits)
+	} else if !bot.KeepTable {
+		bot.TransTable.Clear()
+	}
+	bot.TransTable.NewSearch()
+}
+
+// deepen runs the search with lazy SMP: the main worker and the helpers all
+// deepen the same position and share what they find through the table, so
+// the main worker's iterations find most of their subtrees already searched.
+// Helpers start one ply deeper every other worker to spread them out.
+// It returns the best action of the deepest iteration that finished. If
+// scores isn't nil it is filled with the score of every action of the main
+// worker's last iteration, noise and scores keep to the main worker.
+func (bot *BotPlayer) deepen(board Position, noise []int, scores []int, limitMs int64) int {
+	workers := bot.Workers
+	if workers <= 0 {
+		workers = DefaultBotWorkers
+	}
+	
+	stop := &atomic.Bool{}
+	searches := make([]*search, max(workers, 1))
+	for i := range searches {
+		searches[i] = &search{bot: bot, start: bot.StartTime, limitMs: limitMs, stop: stop, best: -1}
+	}
+	
+	var wg sync.WaitGroup
+	for i, helper := range searches[1:] {
+		wg.Add(1)
+		go func(helper *search, firstDepth int) {
+			defer wg.Done()
+			helper.deepen(board, firstDepth, nil, nil)
+		}(helper, 1+(i+1)%2)
+	}
+	searches[0].deepen(board, 1, noise, scores)
+	stop.Store(true)
+	wg.Wait()
+	
+	// A helper that got deeper has the better move, unless the main worker's
+	// result has to match its scores or noise
+	best := searches[0]
+	for _, helper := range searches[1:] {
+		if scores == nil && bot.Settings.Noise == 0 && helper.depth > best.depth {
+			best = helper
+		}
+	}
+	for _, s := range searches {
+		bot.NodesExplored += s.nodes
+		bot.TableProbes += s.probes
+		bot.TableHits += s.hits
+	}
+	bot.Depth = best.depth
+	bot.Score = best.score
+	bot.pv = best.pv
+	return best.best
+}
+
+// deepen searches one ply deeper at a time from firstDepth until the depth or
+// time limit and keeps the best action of the last iteration that finished.
+// If scores isn't nil it is filled with the score of every action of that iteration.
+func (s *search) deepen(board Position, firstDepth int, noise []int, scores []int) {
+	bot := s.bot
 	
 	// Count empty slots to determine search depth
-	emptySlots := bot.countEmptySlots(game.Board)
-	depthLimit := MaxDepth
+	emptySlots := bot.countEmptySlots(board)
+	depthLimit := bot.Settings.Depth
 	
 	// Adjust depth based on number of empty slots
-	if emptySlots < (BoardHeight*BoardWidth)/3 {
-		depthLimit = 9 // Go deeper in endgame
+	if emptySlots < (board.Height()*board.Width())/3 {
+		depthLimit = bot.Settings.EndgameDepth // Go deeper in endgame
 	}
 	
+	// Any legal move beats none if not even the first iteration finishes:
+	// the table's move for the position if it has one, else the most central
+	tableMove := -1
+	if entry, found := bot.TransTable.Probe(TableKey(board, bot.PlayerToken)); found {
+		tableMove = int(entry.Move)
+	}
+	for _, action := range bot.orderedActions(board, tableMove) {
+		boardCopy := board
+		if bot.playAction(&boardCopy, action, bot.PlayerToken) {
+			s.best = action
+			break
+		}
+	}
+	var iteration []int
+	if scores != nil {
+		iteration = make([]int, len(scores))
+	}
+	for depth := firstDepth; depth <= depthLimit; depth++ {
+		action, score, line := s.searchRoot(board, depth, noise, iteration)
+		if s.timedOut || action == -1 {
+			break
+		}
+		s.best = action
+		s.score = score
+		s.depth = depth
+		s.pv = line
+		copy(scores, iteration)
+		
+		// Stop once the result is certain, or nothing deeper is left to search
+		if isDecisive(score) || (board.Variant() != VariantPopOut && depth >= emptySlots) {
+			break
+		}
+	}
+}
+
+// searchRoot searches every action of the bot to the depth and returns the
+// best one with its score and the best line of play starting with it. If
+// scores isn't nil the exact score of every action is stored in it.
+func (s *search) searchRoot(board Position, depth int, noise []int, scores []int) (int, int, []int) {
+	bot := s.bot
 	bestScore := math.MinInt32
 	bestMove := -1
+	var bestLine []int
 	
-	// Try each column
-	for col := 0; col < BoardWidth; col++ {
-		if bot.isValidMove(game.Board, col) {
-			// Make a copy of the board
-			boardCopy := bot.copyBoard(game.Board)
-			
-			// Simulate the move
-			row := bot.getNextAvailableRow(boardCopy, col)
-			boardCopy[row][col] = bot.PlayerToken
-			
-			// If this is a winning move, return it immediately
-			if bot.checkWin(boardCopy, row, col, bot.PlayerToken) {
-				return col
-			}
-			
-			// Evaluate the move
-			score := bot.minimax(boardCopy, depthLimit-1, math.MinInt32, math.MaxInt32, false)
-			
-			// Check if time is running out
-			if time.Since(bot.StartTime).Milliseconds() > TimeLimit {
-				// If we're running out of time, use the best move found so far
-				if bestMove == -1 {
-					bestMove = col // At least return a valid move
-				}
-				break
-			}
+	// With noise or scores a move's score is needed exactly, not just whether it beats the best so far
+	exact := (bot.Settings.Noise != 0 && noise != nil) || scores != nil
+	alpha := math.MinInt32
+
+	// Try the previous best move first, then the columns from the center out
+	pvAction, tableMove := -1, -1
+	if len(s.pv) > 0 {
+		pvAction = s.pv[0]
+	}
+	if entry, found := bot.TransTable.Probe(TableKey(board, bot.PlayerToken)); found {
+		tableMove = int(entry.Move)
+	}
+	for _, action := range bot.orderedActions(board, pvAction, tableMove) {
+		// Make a copy of the board
+		boardCopy := board
+		
+		// Simulate the move
+		if !bot.playAction(&boardCopy, action, bot.PlayerToken) {
+			continue
+		}
+		
+		// Evaluate the move, a pop that completes the opponent's line loses
+		var line []int
+		score := -winScore(1)
+		switch moveWinner(boardCopy, bot.PlayerToken) {
+		case bot.PlayerToken:
+			score = winScore(1)
+		case EmptyCell:
+			score = s.minimax(boardCopy, depth-1, 1, alpha, math.MaxInt32, false, s.onPV(0, action, true), &line)
 			
-			if score > bestScore || (score == bestScore && col == BoardWidth/2) {
-				bestScore = score
-				bestMove = col
+			// Weaker levels misjudge positions that aren't decided yet
+			if !isDecisive(score) && noise != nil {
+				score += noise[action]
 			}
 		}
-	}
-	
-	// Fallback to first valid move if no best move found
-	if bestMove == -1 {
-		for col := 0; col < BoardWidth; col++ {
-			if bot.isValidMove(game.Board, col) {
-				bestMove = col
-				break
+		
+		if s.timedOut {
+			return -1, 0, nil
+		}
+		if scores != nil {
+			scores[action] = score
+		}
+		
+		if score > bestScore {
+			bestScore = score
+			bestMove = action
+			bestLine = append([]int{action}, line...)
+			if !exact {
+				alpha = max(alpha, score)
 			}
 		}
 	}
 	
-	return bestMove
+	return bestMove, bestScore, bestLine
 }
 
-// minimax implements the minimax algorithm with alpha-beta pruning
-func (bot *BotPlayer) minimax(board [][]int, depth int, alpha int, beta int, maximizingPlayer bool) int {
+// minimax implements the minimax algorithm with alpha-beta pruning. ply is
+// the distance from the root, onPV tells whether the node is on the best line
+// of the previous iteration and line is filled with the best line from here.
+// Once the time limit is hit, or the search is stopped, it sets timedOut and
+// its result must be ignored.
+func (s *search) minimax(board Position, depth int, ply int, alpha int, beta int, maximizingPlayer bool, onPV bool, line *[]int) int {
+	bot := s.bot
+	
 	// Check if time limit is approaching
-	if time.Since(bot.StartTime).Milliseconds() > TimeLimit {
-		return 0 // Return neutral score if we're out of time
+	if s.timedOut || s.stop.Load() || time.Since(s.start).Milliseconds() > s.limitMs {
+		s.timedOut = true
+		return 0
 	}
 	
-	bot.NodesExplored++
+	s.nodes++
 	
-	// Check for terminal states
-	boardKey := bot.boardToString(board)
-	if cachedScore, found := bot.TransTable[boardKey]; found {
-		return cachedScore
+	// The same board can come up with either player to move in PopOut games
+	token := bot.OpponentToken
+	if maximizingPlayer {
+		token = bot.PlayerToken
 	}
 	
-	// Check if the board is full
-	if bot.isBoardFull(board) {
+	// Use what an earlier search found out about the position
+	tableKey := TableKey(board, token)
+	tableMove := -1
+	s.probes++
+	if entry, found := bot.TransTable.Probe(tableKey); found {
+		s.hits++
+		tableMove = int(entry.Move)
+		if int(entry.Depth) >= depth {
+			score := fromTableScore(int(entry.Score), ply)
+			switch entry.Bound {
+			case BoundExact:
+				return score
+			case BoundLower:
+				alpha = max(alpha, score)
+			case BoundUpper:
+				beta = min(beta, score)
+			}
+			if beta <= alpha {
+				return score
+			}
+		}
+	}
+	
+	// Check if the board is full and there is nothing left to pop
+	if !board.HasLegalMove(token) {
 		return 0 // Draw
 	}
 	
 	// Check if depth limit reached
 	if depth == 0 {
 		score := bot.evaluateBoard(board)
-		bot.TransTable[boardKey] = score
+		bot.TransTable.Store(tableKey, 0, score, BoundExact, -1)
 		return score
 	}
 	
-	if maximizingPlayer {
-		maxScore := math.MinInt32
+	// The window the score is judged against when it's stored
+	alphaOrig, betaOrig := alpha, beta
+	
+	// The maximizing player counts wins as positive
+	sign := 1
+	if !maximizingPlayer {
+		sign = -1
+	}
+	
+	bestScore := -sign * math.MaxInt32
+	bestAction := -1
+	
+	// Try the previous best moves first, then the columns from the center out
+	pvAction := -1
+	if onPV && ply < len(s.pv) {
+		pvAction = s.pv[ply]
+	}
+	for _, action := range bot.orderedActions(board, pvAction, tableMove) {
+		// Make a copy of the board
+		boardCopy := board
 		
-		// Try each column
-		for col := 0; col < BoardWidth; col++ {
-			if bot.isValidMove(board, col) {
-				// Make a copy of the board
-				boardCopy := bot.copyBoard(board)
-				
-				// Simulate the move
-				row := bot.getNextAvailableRow(boardCopy, col)
-				boardCopy[row][col] = bot.PlayerToken
-				
-				// Check for win
-				if bot.checkWin(boardCopy, row, col, bot.PlayerToken) {
-					return WinScore
-				}
-				
-				score := bot.minimax(boardCopy, depth-1, alpha, beta, false)
-				maxScore = max(maxScore, score)
-				alpha = max(alpha, maxScore)
-				
-				if beta <= alpha {
-					break // Beta cutoff
-				}
-			}
+		// Simulate the move
+		if !bot.playAction(&boardCopy, action, token) {
+			continue
 		}
 		
-		bot.TransTable[boardKey] = maxScore
-		return maxScore
-	} else {
-		minScore := math.MaxInt32
+		// Check for win, a pop can also complete the other player's line
+		var childLine []int
+		score := -sign * winScore(ply+1)
+		switch moveWinner(boardCopy, token) {
+		case token:
+			*line = []int{action}
+			bot.TransTable.Store(tableKey, depth, toTableScore(sign*winScore(ply+1), ply), BoundExact, action)
+			return sign * winScore(ply+1)
+		case EmptyCell:
+			score = s.minimax(boardCopy, depth-1, ply+1, alpha, beta, !maximizingPlayer, s.onPV(ply, action, onPV), &childLine)
+		}
+		if s.timedOut {
+			return 0
+		}
 		
-		// Try each column
-		for col := 0; col < BoardWidth; col++ {
-			if bot.isValidMove(board, col) {
-				// Make a copy of the board
-				boardCopy := bot.copyBoard(board)
-				
-				// Simulate the move
-				row := bot.getNextAvailableRow(boardCopy, col)
-				boardCopy[row][col] = bot.OpponentToken
-				
-				// Check for win
-				if bot.checkWin(boardCopy, row, col, bot.OpponentToken) {
-					return -WinScore
-				}
-				
-				score := bot.minimax(boardCopy, depth-1, alpha, beta, true)
-				minScore = min(minScore, score)
-				beta = min(beta, minScore)
-				
-				if beta <= alpha {
-					break // Alpha cutoff
-				}
-			}
+		if sign*score > sign*bestScore {
+			bestScore = score
+			bestAction = action
+			*line = append([]int{action}, childLine...)
+		}
+		if maximizingPlayer {
+			alpha = max(alpha, bestScore)
+		} else {
+			beta = min(beta, bestScore)
 		}
 		
-		bot.TransTable[boardKey] = minScore
-		return minScore
+		if beta <= alpha {
+			break // Cutoff
+		}
 	}
+	
+	// A score outside the window only bounds the real score
+	bound := BoundExact
+	if bestScore <= alphaOrig {
+		bound = BoundUpper
+	} else if bestScore >= betaOrig {
+		bound = BoundLower
+	}
+	bot.TransTable.Store(tableKey, depth, toTableScore(bestScore, ply), bound, bestAction)
+	return bestScore
+}
+
+// orderedActions returns the actions to try in a position: the preferred
+// actions that aren't -1, then drops and pops from the center out
+func (bot *BotPlayer) orderedActions(board Position, preferred ...int) []int {
+	width := board.Width()
+	actions := make([]int, 0, bot.actionCount(board)+len(preferred))
+	tried := uint64(0)
+	for _, action := range preferred {
+		if action >= 0 && tried&(1<<uint(action)) == 0 {
+			tried |= 1 << uint(action)
+			actions = append(actions, action)
+		}
+	}
+	for base := 0; base < bot.actionCount(board); base += width {
+		for i := 0; i < width; i++ {
+			// Center first, then alternating to the left and right
+			col := width/2 + (1-2*(i%2))*(i+1)/2
+			if width%2 == 0 {
+				col = width/2 - 1 - (1-2*(i%2))*(i+1)/2
+			}
+			if tried&(1<<uint(base+col)) != 0 {
+				continue
+			}
+			actions = append(actions, base+col)
+		}
+	}
+	return actions
+}
+
+// onPV tells whether the child reached by action is still on the previous best line
+func (s *search) onPV(ply int, action int, onPV bool) bool {
+	return onPV && ply < len(s.pv) && s.pv[ply] == action
+}
+
+// toTableScore makes a win or loss score relative to the position at ply,
+// so it stays right when the position is reached at another ply
+func toTableScore(score int, ply int) int {
+	if score >= WinScore-MaxBoardCells {
+		return score + ply
+	}
+	if score <= -(WinScore - MaxBoardCells) {
+		return score - ply
+	}
+	return score
+}
+
+// fromTableScore turns a stored score back into a score relative to the root
+func fromTableScore(score int, ply int) int {
+	if score >= WinScore-MaxBoardCells {
+		return score - ply
+	}
+	if score <= -(WinScore - MaxBoardCells) {
+		return score + ply
+	}
+	return score
+}
+
+// winScore is the score of a win ply moves from the root, quicker wins score higher
+func winScore(ply int) int {
+	return WinScore - ply
+}
+
+// isDecisive tells whether a score is a forced win or loss rather than an evaluation
+func isDecisive(score int) bool {
+	return score >= WinScore-MaxBoardCells || score <= -(WinScore-MaxBoardCells)
+}
+
+// Evaluate scores a position with the bot's profile without searching,
+// positive is good for the bot. The score is a sum of the profile's weights,
+// so it grows linearly with each weight, see cmd/tune.
+func (bot *BotPlayer) Evaluate(board Position) int {
+	return bot.evaluateBoard(board)
 }
 
 // evaluateBoard evaluates the current board position
-func (bot *BotPlayer) evaluateBoard(board [][]int) int {
+func (bot *BotPlayer) evaluateBoard(board Position) int {
 	score := 0
+	width, height, n := board.Width(), board.Height(), board.Connect()
+	window := make([]int, n)
 	
 	// Evaluate horizontal windows
-	for row := 0; row < BoardHeight; row++ {
-		for col := 0; col <= BoardWidth-4; col++ {
-			window := []int{board[row][col], board[row][col+1], board[row][col+2], board[row][col+3]}
+	for row := 0; row < height; row++ {
+		for col := 0; col <= width-n; col++ {
+			for i := range window {
+				window[i] = board.Cell(row, col+i)
+			}
 			score += bot.evaluateWindow(window)
 		}
 	}
 	
 	// Evaluate vertical windows
-	for col := 0; col < BoardWidth; col++ {
-		for row := 0; row <= BoardHeight-4; row++ {
-			window := []int{board[row][col], board[row+1][col], board[row+2][col], board[row+3][col]}
+	for col := 0; col < width; col++ {
+		for row := 0; row <= height-n; row++ {
+			for i := range window {
+				window[i] = board.Cell(row+i, col)
+			}
 			score += bot.evaluateWindow(window)
 		}
 	}
 	
 	// Evaluate diagonal windows (/)
-	for row := 3; row < BoardHeight; row++ {
-		for col := 0; col <= BoardWidth-4; col++ {
-			window := []int{board[row][col], board[row-1][col+1], board[row-2][col+2], board[row-3][col+3]}
+	for row := n - 1; row < height; row++ {
+		for col := 0; col <= width-n; col++ {
+			for i := range window {
+				window[i] = board.Cell(row-i, col+i)
+			}
 			score += bot.evaluateWindow(window)
 		}
 	}
 	
 	// Evaluate diagonal windows (\)
-	for row := 0; row <= BoardHeight-4; row++ {
-		for col := 0; col <= BoardWidth-4; col++ {
-			window := []int{board[row][col], board[row+1][col+1], board[row+2][col+2], board[row+3][col+3]}
+	for row := 0; row <= height-n; row++ {
+		for col := 0; col <= width-n; col++ {
+			for i := range window {
+				window[i] = board.Cell(row+i, col+i)
+			}
 			score += bot.evaluateWindow(window)
 		}
 	}
 	
 	// Center column preference
-	centerCol := BoardWidth / 2
+	centerCol := width / 2
 	centerCount := 0
-	for row := 0; row < BoardHeight; row++ {
-		if board[row][centerCol] == bot.PlayerToken {
+	for row := 0; row < height; row++ {
+		if board.Cell(row, centerCol) == bot.PlayerToken {
 			centerCount++
 		}
 	}
-	score += centerCount * 3
+	score += centerCount * bot.Profile.Center
 	
 	return score
 }
 
-// evaluateWindow evaluates a window of 4 positions
+// evaluateWindow evaluates a window of connect-length positions with the bot's profile
 func (bot *BotPlayer) evaluateWindow(window []int) int {
 	playerCount := 0
 	opponentCount := 0
 	emptyCount := 0
+	n := len(window)
 	
 	for _, cell := range window {
 		if cell == bot.PlayerToken {
@@ -261,130 +682,90 @@ func (bot *BotPlayer) evaluateWindow(window []int) int {
 	}
 	
 	// Score the window
-	if playerCount == 4 {
+	profile := &bot.Profile
+	if playerCount == n {
 		return WinScore
-	} else if playerCount == 3 && emptyCount == 1 {
-		return ThreeInRow
-	} else if playerCount == 2 && emptyCount == 2 {
-		return TwoInRow
-	} else if playerCount == 1 && emptyCount == 3 {
-		return OneInRow
+	} else if playerCount == n-1 && emptyCount == 1 {
+		return profile.ThreeInRow
+	} else if playerCount == n-2 && emptyCount == 2 {
+		return profile.TwoInRow
+	} else if playerCount == 1 && emptyCount == n-1 {
+		return profile.OneInRow
 	}
 	
 	// Penalty for opponent threats
-	if opponentCount == 3 && emptyCount == 1 {
-		return -ThreeInRow * 2 // Prioritize blocking opponent wins
-	} else if opponentCount == 2 && emptyCount == 2 {
-		return -TwoInRow
+	if opponentCount == n-1 && emptyCount == 1 {
+		return -profile.BlockThree // Prioritize blocking opponent wins
+	} else if opponentCount == n-2 && emptyCount == 2 {
+		return -profile.BlockTwo
 	}
 	
 	return 0
 }
 
 // Helper functions
-func (bot *BotPlayer) isValidMove(board [][]int, col int) bool {
-	return col >= 0 && col < BoardWidth && board[0][col] == EmptyCell
+// actionCount returns how many actions the search tries in a position.
+// Actions below the board width drop a disc in that column, in PopOut
+// games the next width actions pop a disc from a column.
+func (bot *BotPlayer) actionCount(board Position) int {
+	if board.Variant() == VariantPopOut {
+		return 2 * board.Width()
+	}
+	return board.Width()
 }
 
-func (bot *BotPlayer) getNextAvailableRow(board [][]int, col int) int {
-	for row := BoardHeight - 1; row >= 0; row-- {
-		if board[row][col] == EmptyCell {
-			return row
+// playAction plays an action for the token if it is legal
+func (bot *BotPlayer) playAction(board *Position, action, token int) bool {
+	width := board.Width()
+	if action < width {
+		if !board.CanPlay(action) {
+			return false
 		}
-	}
-	return -1 // Column is full
-}
-
-func (bot *BotPlayer) copyBoard(board [][]int) [][]int {
-	newBoard := make([][]int, len(board))
-	for i := range board {
-		newBoard[i] = make([]int, len(board[i]))
-		copy(newBoard[i], board[i])
-	}
-	return newBoard
-}
-
-func (bot *BotPlayer) checkWin(board [][]int, row, col, playerToken int) bool {
-	// Check horizontal
-	count := 1
-	for c := col + 1; c < BoardWidth && board[row][c] == playerToken; c++ {
-		count++
-	}
-	for c := col - 1; c >= 0 && board[row][c] == playerToken; c-- {
-		count++
-	}
-	if count >= 4 {
-		return true
-	}
-	
-	// Check vertical
-	count = 1
-	for r := row + 1; r < BoardHeight && board[r][col] == playerToken; r++ {
-		count++
-	}
-	for r := row - 1; r >= 0 && board[r][col] == playerToken; r-- {
-		count++
-	}
-	if count >= 4 {
+		board.Play(action, token)
 		return true
 	}
 	
-	// Check diagonal (/)
-	count = 1
-	for i := 1; row - i >= 0 && col + i < BoardWidth && board[row-i][col+i] == playerToken; i++ {
-		count++
-	}
-	for i := 1; row + i < BoardHeight && col - i >= 0 && board[row+i][col-i] == playerToken; i++ {
-		count++
-	}
-	if count >= 4 {
-		return true
-	}
-	
-	// Check diagonal (\)
-	count = 1
-	for i := 1; row - i >= 0 && col - i >= 0 && board[row-i][col-i] == playerToken; i++ {
-		count++
-	}
-	for i := 1; row + i < BoardHeight && col + i < BoardWidth && board[row+i][col+i] == playerToken; i++ {
-		count++
-	}
-	if count >= 4 {
-		return true
+	if !board.CanPop(action-width, token) {
+		return false
 	}
-	
-	return false
+	board.Pop(action - width)
+	return true
 }
 
-func (bot *BotPlayer) isBoardFull(board [][]int) bool {
-	for col := 0; col < BoardWidth; col++ {
-		if board[0][col] == EmptyCell {
-			return false
-		}
+// actionMove converts a search action into a move for the bot
+func (bot *BotPlayer) actionMove(board Position, action int) Move {
+	if action >= board.Width() {
+		return Move{PlayerID: bot.PlayerID, Column: action - board.Width(), Kind: MovePop}
 	}
-	return true
+	return Move{PlayerID: bot.PlayerID, Column: action, Kind: MoveDrop}
 }
 
-func (bot *BotPlayer) countEmptySlots(board [][]int) int {
-	count := 0
-	for row := 0; row < BoardHeight; row++ {
-		for col := 0; col < BoardWidth; col++ {
-			if board[row][col] == EmptyCell {
-				count++
-			}
-		}
+// moveWinner returns the token that won after token moved, or EmptyCell.
+// The mover wins when a pop completes lines for both players.
+func moveWinner(board Position, token int) int {
+	if board.HasWon(token) {
+		return token
+	}
+	if board.HasWon(otherToken(token)) {
+		return otherToken(token)
 	}
-	return count
+	return EmptyCell
 }
 
-func (bot *BotPlayer) boardToString(board [][]int) string {
-	result := ""
-	for row := 0; row < BoardHeight; row++ {
-		for col := 0; col < BoardWidth; col++ {
-			result += string(rune('0' + board[row][col]))
+// legalActions returns the actions the bot can play in a position
+func (bot *BotPlayer) legalActions(board Position) []int {
+	legal := []int{}
+	for action := 0; action < bot.actionCount(board); action++ {
+		boardCopy := board
+		if bot.playAction(&boardCopy, action, bot.PlayerToken) {
+			legal = append(legal, action)
 		}
 	}
-	return result
+	return legal
+}
+
+func (bot *BotPlayer) countEmptySlots(board Position) int {
+	return board.Width()*board.Height() - board.MoveCount()
 }
 
 // Helper functions
@@ -408,7 +789,7 @@ func CreateBot(playerID string, playerToken int) *BotPlayer {
 }
 
 // BotsNextMove calculates and returns the best move for the bot
-func BotsNextMove(game *Game) int {
+func BotsNextMove(game *Game) Move {
 	playerToken := YellowToken
 	if game.CurrentTurn == RedToken {
 		playerToken = RedToken
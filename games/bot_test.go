@@ -0,0 +1,62 @@
+package games
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// BenchmarkLazySMP searches the same position for 500 ms with one worker and
+// with several, e.g.
+//
+//	go test ./games -run '^$' -bench LazySMP -benchtime 10x
+//
+// It reports the average deepest finished iteration of both and the depth
+// the extra workers gain. Each move starts with a cleared table, so the
+// workers only share what they find. There are at least 4 workers, with
+// fewer CPUs than workers they take turns and the gain says what that costs.
+func BenchmarkLazySMP(b *testing.B) {
+	pos, token, err := ParseBoard("......./......./......./...Y.../..RR.../..YRY.. R", DefaultRules())
+	if err != nil {
+		b.Fatal(err)
+	}
+	counts := []int{1, max(runtime.NumCPU(), 4)}
+	depths := make([]int, len(counts))
+	for i := 0; i < b.N; i++ {
+		for c, workers := range counts {
+			bot := NewBotPlayer("", token)
+			bot.Settings = LevelSettings{Depth: MaxBoardCells, EndgameDepth: MaxBoardCells, TimeLimitMs: 500}
+			bot.Workers = workers
+			bot.KeepTable = false
+			bot.ChooseMove(pos, token, Budget{TimeLimit: 500 * time.Millisecond})
+			depths[c] += bot.Depth
+		}
+	}
+	for c, workers := range counts {
+		b.ReportMetric(float64(depths[c])/float64(b.N), fmt.Sprintf("depth/workers=%d", workers))
+	}
+	b.ReportMetric(float64(depths[1]-depths[0])/float64(b.N), "depth-gain")
+}
+
+// TestTimedOutFallback checks the move played when not even the first
+// iteration finishes: the table's move if it has one, else the most central
+func TestTimedOutFallback(t *testing.T) {
+	pos := NewPosition(DefaultRules())
+	pos.Play(3, RedToken)
+
+	for _, tableMove := range []int{-1, 5} {
+		bot := NewBotPlayer("", YellowToken)
+		// A negative time limit runs out before the first node
+		bot.Settings = LevelSettings{Depth: 4, EndgameDepth: 4, TimeLimitMs: -1}
+		want := 3
+		if tableMove != -1 {
+			bot.TransTable = NewTransTable(DefaultTableBits)
+			bot.TransTable.Store(TableKey(pos, YellowToken), 4, 0, BoundExact, tableMove)
+			want = tableMove
+		}
+		if move := bot.GetNextMove(&Game{Board: pos}); move.Column != want || move.Kind == MovePop {
+			t.Errorf("table move %d: got %+v, want a drop in column %d", tableMove, move, want)
+		}
+	}
+}
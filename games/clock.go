@@ -0,0 +1,148 @@
+package games
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// TimeControl is a chess style time control, all times are in milliseconds
+type TimeControl struct {
+	InitialMs   int64 `json:"initialMs"`             // Time each player starts with
+	IncrementMs int64 `json:"incrementMs,omitempty"` // Added to the clock after every move
+	DelayMs     int64 `json:"delayMs,omitempty"`     // Thinking time per move before the clock starts running
+}
+
+// Validate checks that the time control can be played
+func (tc TimeControl) Validate() error {
+	if tc.InitialMs <= 0 {
+		return errors.New("initial time must be positive")
+	}
+	if tc.IncrementMs < 0 || tc.DelayMs < 0 {
+		return errors.New("increment and delay can't be negative")
+	}
+	return nil
+}
+
+// Clock keeps the remaining time of both players. Only the clock of the
+// player to move runs, the server's copy is the authoritative one.
+type Clock struct {
+	TimeControl
+	remaining map[int]time.Duration // by token
+	running   int                   // token whose clock is running, EmptyCell when stopped
+	turnStart time.Time
+}
+
+// NewClock creates a stopped clock with the initial time on both sides
+func NewClock(tc TimeControl) *Clock {
+	initial := time.Duration(tc.InitialMs) * time.Millisecond
+	return &Clock{
+		TimeControl: tc,
+		remaining: map[int]time.Duration{
+			RedToken:    initial,
+			YellowToken: initial,
+		},
+		running: EmptyCell,
+	}
+}
+
+// Start runs the token's clock from now
+func (c *Clock) Start(token int, now time.Time) {
+	c.running = token
+	c.turnStart = now
+}
+
+// Stop charges the running player for the time used and stops the clock
+func (c *Clock) Stop(now time.Time) {
+	if c.running == EmptyCell {
+		return
+	}
+	c.remaining[c.running] = c.Remaining(c.running, now)
+	c.running = EmptyCell
+}
+
+// Press ends the running player's turn: their time is charged, the increment
+// is added and the clock of the other player starts
+func (c *Clock) Press(now time.Time) {
+	if c.running == EmptyCell {
+		return
+	}
+	token := c.running
+	c.Stop(now)
+	c.remaining[token] += time.Duration(c.IncrementMs) * time.Millisecond
+	c.Start(otherToken(token), now)
+}
+
+// Remaining returns the token's time left at now, never less than zero
+func (c *Clock) Remaining(token int, now time.Time) time.Duration {
+	left := c.remaining[token]
+	if token == c.running {
+		used := now.Sub(c.turnStart) - time.Duration(c.DelayMs)*time.Millisecond
+		if used > 0 {
+			left -= used
+		}
+	}
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// Running returns the token whose clock is running, EmptyCell when stopped
+func (c *Clock) Running() int {
+	return c.running
+}
+
+// FlagTime returns when the running player runs out of time
+func (c *Clock) FlagTime() time.Time {
+	return c.turnStart.Add(time.Duration(c.DelayMs)*time.Millisecond + c.remaining[c.running])
+}
+
+// MarshalJSON writes the time control with both players' time left right now
+func (c *Clock) MarshalJSON() ([]byte, error) {
+	now := time.Now()
+	return json.Marshal(struct {
+		TimeControl
+		RedMs    int64 `json:"redMs"`
+		YellowMs int64 `json:"yellowMs"`
+		Running  int   `json:"running"`
+	}{
+		TimeControl: c.TimeControl,
+		RedMs:       c.Remaining(RedToken, now).Milliseconds(),
+		YellowMs:    c.Remaining(YellowToken, now).Milliseconds(),
+		Running:     c.running,
+	})
+}
+
+// SetTimeControl gives the game a fresh clock, nil removes it
+func (g *Game) SetTimeControl(tc *TimeControl) {
+	if tc == nil {
+		g.Clock = nil
+		return
+	}
+	g.Clock = NewClock(*tc)
+}
+
+// Start makes the game active and starts the clock of the player to move
+func (g *Game) Start() {
+	g.Status = StatusActive
+	if g.Clock != nil {
+		g.Clock.Start(g.CurrentTurn, time.Now())
+	}
+}
+
+// CheckFlag ends the game with a timeout if the player to move ran out of
+// time at now. It reports whether the game ended.
+func (g *Game) CheckFlag(now time.Time) bool {
+	if g.Status != StatusActive || g.Clock == nil || g.Clock.Running() == EmptyCell {
+		return false
+	}
+	token := g.Clock.Running()
+	if g.Clock.Remaining(token, now) > 0 {
+		return false
+	}
+
+	g.Clock.Stop(now)
+	g.finish(OutcomeTimeout, otherToken(token))
+	return true
+}
@@ -0,0 +1,65 @@
+package games
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockPress(t *testing.T) {
+	tests := []struct {
+		name string
+		tc   TimeControl
+		used time.Duration // Time red takes for the move
+		want time.Duration // Red's time left after pressing
+	}{
+		{"plain", TimeControl{InitialMs: 60000}, 5 * time.Second, 55 * time.Second},
+		{"increment", TimeControl{InitialMs: 60000, IncrementMs: 2000}, 5 * time.Second, 57 * time.Second},
+		{"increment beyond the initial time", TimeControl{InitialMs: 60000, IncrementMs: 2000}, time.Second, 61 * time.Second},
+		{"within the delay", TimeControl{InitialMs: 60000, DelayMs: 3000}, 2 * time.Second, 60 * time.Second},
+		{"beyond the delay", TimeControl{InitialMs: 60000, DelayMs: 3000}, 5 * time.Second, 58 * time.Second},
+		{"delay and increment", TimeControl{InitialMs: 60000, IncrementMs: 1000, DelayMs: 3000}, 5 * time.Second, 59 * time.Second},
+	}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		clock := NewClock(test.tc)
+		clock.Start(RedToken, start)
+		now := start.Add(test.used)
+		clock.Press(now)
+		if got := clock.Remaining(RedToken, now); got != test.want {
+			t.Errorf("%s: red has %v left, want %v", test.name, got, test.want)
+		}
+		if clock.Running() != YellowToken || clock.Remaining(YellowToken, now) != time.Duration(test.tc.InitialMs)*time.Millisecond {
+			t.Errorf("%s: yellow's clock didn't start with the full time", test.name)
+		}
+	}
+}
+
+// TestClockFlag checks the flag falls exactly when the time and the delay
+// run out, not a moment earlier
+func TestClockFlag(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	game := NewGame(LocalMultiplayer, "alice", "bob", DefaultRules())
+	game.SetTimeControl(&TimeControl{InitialMs: 10000, IncrementMs: 500, DelayMs: 1000})
+	game.Start()
+	game.Clock.Start(RedToken, start)
+
+	flag := game.Clock.FlagTime()
+	if want := start.Add(11 * time.Second); !flag.Equal(want) {
+		t.Fatalf("flag at %v, want %v", flag, want)
+	}
+	if left := game.Clock.Remaining(RedToken, flag.Add(-time.Millisecond)); left != time.Millisecond {
+		t.Errorf("a millisecond before the flag %v are left", left)
+	}
+	if game.CheckFlag(flag.Add(-time.Nanosecond)) {
+		t.Fatal("the flag fell before the time ran out")
+	}
+	if !game.CheckFlag(flag) {
+		t.Fatal("the flag didn't fall when the time ran out")
+	}
+	if game.Status != StatusFinished || game.Outcome != OutcomeTimeout || game.WinnerID != "bob" {
+		t.Errorf("got %s %s won by %q, want a timeout won by bob", game.Status, game.Outcome, game.WinnerID)
+	}
+	if game.Clock.Running() != EmptyCell || game.Clock.Remaining(RedToken, flag) != 0 {
+		t.Error("the clock kept running after the flag fell")
+	}
+}
@@ -3,6 +3,11 @@ package games
 const (
 	BoardWidth  = 7
 	BoardHeight = 6
+	ConnectLength = 4 // Discs in a line needed to win by default
+
+	// Largest board a bitboard can hold, every column needs height+1 bits of a uint64
+	MaxBoardWidth = 16
+	MaxBoardCells = 64
 	
 	// Player tokens
 	EmptyCell = 0
@@ -13,9 +18,22 @@ const (
 	StatusActive   GameStatus = "active"
 	StatusFinished GameStatus = "finished"
 
+	OutcomeWin         Outcome = "win"
+	OutcomeDraw        Outcome = "draw"
+	OutcomeResignation Outcome = "resignation"
+	OutcomeTimeout     Outcome = "timeout"
+	OutcomeAbandonment Outcome = "abandonment"
+
+	VariantStandard Variant = "standard"
+	VariantPopOut   Variant = "popout" // Players may also pop their own disc from the bottom row
+
+	MoveDrop MoveKind = "drop"
+	MovePop  MoveKind = "pop"
+
 	SinglePlayer GameType = "single"
 	LocalMultiplayer GameType = "local"
 	OnlineMultiplayer GameType = "online"
+	Exhibition GameType = "exhibition" // Two bots playing each other, driven by the server
 
 
 )
\ No newline at end of file
@@ -0,0 +1,182 @@
+package games
+
+import (
+	"errors"
+	"time"
+)
+
+// BotLevel is a named difficulty for the bot
+type BotLevel string
+
+const (
+	BotBeginner BotLevel = "beginner"
+	BotEasy     BotLevel = "easy"
+	BotMedium   BotLevel = "medium"
+	BotHard     BotLevel = "hard"
+	BotPerfect  BotLevel = "perfect"
+
+	DefaultBotLevel = BotHard
+)
+
+// LevelSettings are the search limits and handicaps of a bot level
+type LevelSettings struct {
+	Depth        int     // Search depth in plies
+	EndgameDepth int     // Search depth once less than a third of the board is empty
+	TimeLimitMs  int64   // Time budget per move
+	Noise        int     // Random amount up to this size is added to every root score
+	MistakeRate  float64 // Chance of playing a random legal move instead of searching
+	Solve        bool    // Play the solver's move when it finds one in time, see Solve
+}
+
+// botLevels holds the settings of every level, from weakest to strongest
+var botLevels = map[BotLevel]LevelSettings{
+	BotBeginner: {Depth: 2, EndgameDepth: 2, TimeLimitMs: 200, Noise: 400, MistakeRate: 0.3},
+	BotEasy:     {Depth: 4, EndgameDepth: 5, TimeLimitMs: 400, Noise: 100, MistakeRate: 0.15},
+	BotMedium:   {Depth: 6, EndgameDepth: 7, TimeLimitMs: 700, Noise: 20, MistakeRate: 0.05},
+	BotHard:     {Depth: MaxDepth, EndgameDepth: 9, TimeLimitMs: TimeLimit},
+	BotPerfect:  {Depth: MaxBoardCells, EndgameDepth: MaxBoardCells, TimeLimitMs: 3000, Solve: true},
+}
+
+// Settings returns the settings of a level
+func (l BotLevel) Settings() (LevelSettings, error) {
+	settings, ok := botLevels[l]
+	if !ok {
+		return LevelSettings{}, errors.New("unknown bot level")
+	}
+	return settings, nil
+}
+
+// SetLevel changes the bot's difficulty
+func (bot *BotPlayer) SetLevel(level BotLevel) error {
+	settings, err := level.Settings()
+	if err != nil {
+		return err
+	}
+	bot.Level = level
+	bot.Settings = settings
+	return nil
+}
+
+// SetBotLevel changes the difficulty of the game's bots, it is kept when the game is reset.
+// Strategies without levels play the same at every level.
+func (g *Game) SetBotLevel(level BotLevel) error {
+	if !g.HasBot() {
+		return errors.New("game has no bot")
+	}
+	if _, err := level.Settings(); err != nil {
+		return err
+	}
+	for _, bot := range g.bots {
+		if leveled, ok := bot.(LeveledStrategy); ok {
+			leveled.SetLevel(level)
+		}
+	}
+	g.BotLevel = level
+	g.SeatBotLevels = nil
+	return nil
+}
+
+// SeatLevels is the difficulty of the bot of each color, empty for a seat
+// without a bot with levels
+type SeatLevels struct {
+	Red    BotLevel `json:"red,omitempty"`
+	Yellow BotLevel `json:"yellow,omitempty"`
+}
+
+// SetSeatBotLevel changes the difficulty of the bot playing the token only,
+// so two bots of a game can play at different levels
+func (g *Game) SetSeatBotLevel(token int, level BotLevel) error {
+	leveled, ok := g.BotFor(token).(LeveledStrategy)
+	if !ok {
+		return errors.New("no bot with levels plays this color")
+	}
+	if err := leveled.SetLevel(level); err != nil {
+		return err
+	}
+
+	// Until now every bot played at BotLevel
+	if g.SeatBotLevels == nil {
+		g.SeatBotLevels = &SeatLevels{}
+		if _, ok := g.BotFor(RedToken).(LeveledStrategy); ok {
+			g.SeatBotLevels.Red = g.BotLevel
+		}
+		if _, ok := g.BotFor(YellowToken).(LeveledStrategy); ok {
+			g.SeatBotLevels.Yellow = g.BotLevel
+		}
+	}
+	if token == RedToken {
+		g.SeatBotLevels.Red = level
+	} else {
+		g.SeatBotLevels.Yellow = level
+	}
+
+	// BotLevel only names a level that every bot plays at
+	red, yellow := g.SeatBotLevels.Red, g.SeatBotLevels.Yellow
+	switch {
+	case red == yellow || yellow == "":
+		g.BotLevel = red
+	case red == "":
+		g.BotLevel = yellow
+	default:
+		g.BotLevel = ""
+	}
+	return nil
+}
+
+// noise returns a random score adjustment for the bot's level
+func (bot *BotPlayer) noise() int {
+	if bot.Settings.Noise <= 0 {
+		return 0
+	}
+	return bot.rng.Intn(2*bot.Settings.Noise+1) - bot.Settings.Noise
+}
+
+// randomAction returns a random legal action, -1 if there is none
+func (bot *BotPlayer) randomAction(board Position) int {
+	legal := bot.legalActions(board)
+	if len(legal) == 0 {
+		return -1
+	}
+	return legal[bot.rng.Intn(len(legal))]
+}
+
+// SolverMinPly is the first ply the bot tries the solver on, earlier
+// positions go to the normal search straight away.
+const SolverMinPly = 12
+
+// solvedAction returns the best column according to the solver, -1 if the
+// position is before SolverMinPly, the solver can't play it or doesn't finish
+// in two thirds of the time limit. The rest of the time is left for a normal search.
+func (bot *BotPlayer) solvedAction(board Position, limitMs int64) int {
+	if board.MoveCount() < SolverMinPly {
+		return -1
+	}
+	deadline := bot.StartTime.Add(time.Duration(limitMs*2/3) * time.Millisecond)
+	solver := solverPool.Get().(*Solver)
+	defer solverPool.Put(solver)
+	results, err := solver.SolveColumns(board, bot.PlayerToken, deadline)
+	bot.NodesExplored = int(solver.Nodes)
+	if err != nil {
+		return -1
+	}
+
+	// Win the quickest way, or lose the slowest, center columns break ties
+	best := -1
+	for _, col := range bot.orderedActions(board) {
+		if results[col] != nil && (best == -1 || results[col].Score > results[best].Score) {
+			best = col
+		}
+	}
+	if best == -1 {
+		return -1
+	}
+
+	// The solver's result in the search's scale, a win in fewer plies is worth more
+	switch results[best].Value {
+	case SolveWin:
+		bot.Score = winScore(results[best].Plies)
+	case SolveLoss:
+		bot.Score = -winScore(results[best].Plies)
+	}
+	return best
+}
@@ -0,0 +1,358 @@
+package games
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// mctsMaxNodes bounds the tree at about 25 MB, a node takes close to 190
+// bytes with its slices. Once it is reached the search keeps running
+// playouts from the leaves without growing the tree.
+const mctsMaxNodes = 1 << 17
+
+// MCTSSettings are the search limits of the MCTS bot. The search stops at
+// whichever of the iteration count and the time limit comes first.
+type MCTSSettings struct {
+	Iterations  int     // Playouts per move, 0 for no limit
+	TimeLimitMs int64   // Time budget per move
+	Exploration float64 // UCT exploration constant, higher tries weaker moves more often
+	Heuristic   bool    // Playouts take wins and block losses instead of playing at random
+}
+
+// mctsLevels holds the settings of every level. Small budgets make human
+// like mistakes rather than the random ones of the weak minimax levels.
+var mctsLevels = map[BotLevel]MCTSSettings{
+	BotBeginner: {Iterations: 200, TimeLimitMs: 200, Exploration: math.Sqrt2},
+	BotEasy:     {Iterations: 1500, TimeLimitMs: 400, Exploration: math.Sqrt2, Heuristic: true},
+	BotMedium:   {Iterations: 8000, TimeLimitMs: 700, Exploration: math.Sqrt2, Heuristic: true},
+	BotHard:     {TimeLimitMs: TimeLimit, Exploration: math.Sqrt2, Heuristic: true},
+	BotPerfect:  {TimeLimitMs: 3000, Exploration: math.Sqrt2, Heuristic: true},
+}
+
+// MCTSPlayer is a Monte Carlo tree search bot with UCT selection. It keeps
+// its tree from one move to the next when the game continues from the
+// position it played into.
+type MCTSPlayer struct {
+	Level      BotLevel
+	Settings   MCTSSettings
+	Iterations int // Playouts run for the last move, for statistics
+
+	root      *mctsNode
+	rootBoard Position
+	rootToken int // Token to move at the root
+	nodes     int // Nodes in the tree, roughly
+	rng       *rand.Rand
+	last      SearchInfo // How the last move was found, see LastSearch
+}
+
+// mctsNode is a position of the search tree, reached by its parent's player playing action
+type mctsNode struct {
+	parent   *mctsNode
+	children []*mctsNode
+	untried  []int // Actions without a child yet
+
+	action int // Drop below the board width, pop of column action-width above
+	mover  int // Token that played action
+
+	visits int
+	reward float64 // Sum of the playout results for mover, 1 a win and 0.5 a draw
+
+	terminal bool
+	winner   int // Winner of a terminal node, EmptyCell for a draw
+}
+
+// NewMCTSPlayer creates an MCTS bot at the default level
+func NewMCTSPlayer() *MCTSPlayer {
+	return &MCTSPlayer{
+		Level:    DefaultBotLevel,
+		Settings: mctsLevels[DefaultBotLevel],
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// SetLevel changes the bot's budget
+func (m *MCTSPlayer) SetLevel(level BotLevel) error {
+	settings, ok := mctsLevels[level]
+	if !ok {
+		return errors.New("unknown bot level")
+	}
+	m.Level = level
+	m.Settings = settings
+	return nil
+}
+
+// Seed makes the bot's playouts repeatable
+func (m *MCTSPlayer) Seed(seed int64) {
+	m.rng = rand.New(rand.NewSource(seed))
+}
+
+// ChooseMove searches the position and plays the most visited move
+func (m *MCTSPlayer) ChooseMove(board Position, token int, budget Budget) Move {
+	start := time.Now()
+	limit := time.Duration(m.Settings.TimeLimitMs) * time.Millisecond
+	if budget.TimeLimit > 0 && budget.TimeLimit < limit {
+		limit = budget.TimeLimit
+	}
+
+	// A move that wins at once needs no thought
+	m.Iterations = 0
+	for _, action := range boardActions(board, token) {
+		boardCopy := board
+		applyAction(&boardCopy, action, token)
+		if moveWinner(boardCopy, token) == token {
+			move := actionToMove(board, action)
+			m.last = SearchInfo{Source: SourcePlayouts, Depth: 1, ElapsedMs: time.Since(start).Milliseconds(), WinRate: 1, PV: []Move{move}}
+			return move
+		}
+	}
+
+	m.reuseTree(board, token)
+	for m.Settings.Iterations == 0 || m.Iterations < m.Settings.Iterations {
+		// Checking the clock every playout would cost more than the playout
+		if m.Iterations%64 == 0 && time.Since(start) >= limit {
+			break
+		}
+		m.iterate()
+		m.Iterations++
+	}
+
+	best := mostVisited(m.root)
+	m.recordSearch(board, best, time.Since(start))
+	if best == nil {
+		// Not even one playout ran, any legal move will do
+		if len(m.root.untried) == 0 {
+			return Move{Column: -1}
+		}
+		return actionToMove(board, m.root.untried[0])
+	}
+	return actionToMove(board, best.action)
+}
+
+// Release drops the tree once the game is over
+func (m *MCTSPlayer) Release() {
+	m.root = nil
+	m.nodes = 0
+}
+
+// LastSearch tells how the bot found its last move
+func (m *MCTSPlayer) LastSearch() SearchInfo {
+	return m.last
+}
+
+// recordSearch keeps the statistics of the search that chose best, see LastSearch.
+// The line of play follows the most visited children down the tree.
+func (m *MCTSPlayer) recordSearch(board Position, best *mctsNode, elapsed time.Duration) {
+	info := SearchInfo{Source: SourcePlayouts, Nodes: m.Iterations, ElapsedMs: elapsed.Milliseconds()}
+	if elapsed > 0 {
+		info.NPS = int(float64(m.Iterations) / elapsed.Seconds())
+	}
+	if best != nil && best.visits > 0 {
+		info.WinRate = best.reward / float64(best.visits)
+	}
+	for node := best; node != nil; node = mostVisited(node) {
+		info.PV = append(info.PV, actionToMove(board, node.action))
+	}
+	info.Depth = treeDepth(m.root) - 1
+	m.last = info
+}
+
+// mostVisited returns the child of the node that was searched most, nil if it has none
+func mostVisited(node *mctsNode) *mctsNode {
+	var best *mctsNode
+	for _, child := range node.children {
+		if best == nil || child.visits > best.visits {
+			best = child
+		}
+	}
+	return best
+}
+
+// treeDepth returns the number of levels of the tree below and including the node
+func treeDepth(node *mctsNode) int {
+	depth := 0
+	for _, child := range node.children {
+		depth = max(depth, treeDepth(child))
+	}
+	return depth + 1
+}
+
+// reuseTree makes the tree's root the position to search. The subtree of
+// the position is kept if it is in the tree, two plies below the old root.
+func (m *MCTSPlayer) reuseTree(board Position, token int) {
+	if m.root != nil && token == m.rootToken {
+		if m.rootBoard == board {
+			return
+		}
+		for _, child := range m.root.children {
+			for _, grandchild := range child.children {
+				next := m.rootBoard
+				applyAction(&next, child.action, child.mover)
+				applyAction(&next, grandchild.action, grandchild.mover)
+				if next == board && !grandchild.terminal {
+					grandchild.parent = nil
+					m.root = grandchild
+					m.rootBoard = board
+					m.nodes = grandchild.visits + 1
+					return
+				}
+			}
+		}
+	}
+
+	m.root = &mctsNode{action: -1, mover: otherToken(token), untried: boardActions(board, token)}
+	m.rootBoard = board
+	m.rootToken = token
+	m.nodes = 1
+}
+
+// iterate runs one round of selection, expansion, playout and backpropagation
+func (m *MCTSPlayer) iterate() {
+	node := m.root
+	board := m.rootBoard
+
+	// Selection: follow the best UCT child down to a node that isn't fully expanded
+	for !node.terminal && len(node.untried) == 0 && len(node.children) > 0 {
+		node = m.selectChild(node)
+		applyAction(&board, node.action, node.mover)
+	}
+
+	// Expansion: add one untried move as a new child
+	if !node.terminal && len(node.untried) > 0 && m.nodes < mctsMaxNodes {
+		i := m.rng.Intn(len(node.untried))
+		action := node.untried[i]
+		node.untried[i] = node.untried[len(node.untried)-1]
+		node.untried = node.untried[:len(node.untried)-1]
+
+		mover := otherToken(node.mover)
+		applyAction(&board, action, mover)
+		child := &mctsNode{parent: node, action: action, mover: mover}
+		child.winner = moveWinner(board, mover)
+		if child.winner != EmptyCell || !board.HasLegalMove(otherToken(mover)) {
+			child.terminal = true
+		} else {
+			child.untried = boardActions(board, otherToken(mover))
+		}
+		node.children = append(node.children, child)
+		node = child
+		m.nodes++
+	}
+
+	// Playout: play the game out from the new node
+	winner := node.winner
+	if !node.terminal {
+		winner = m.playout(board, otherToken(node.mover))
+	}
+
+	// Backpropagation: every node on the way scores the result for its mover
+	for ; node != nil; node = node.parent {
+		node.visits++
+		if winner == node.mover {
+			node.reward++
+		} else if winner == EmptyCell {
+			node.reward += 0.5
+		}
+	}
+}
+
+// selectChild returns the child with the highest upper confidence bound
+func (m *MCTSPlayer) selectChild(node *mctsNode) *mctsNode {
+	var best *mctsNode
+	bestValue := math.Inf(-1)
+	logVisits := math.Log(float64(node.visits))
+	for _, child := range node.children {
+		value := child.reward/float64(child.visits) + m.Settings.Exploration*math.Sqrt(logVisits/float64(child.visits))
+		if value > bestValue {
+			best, bestValue = child, value
+		}
+	}
+	return best
+}
+
+// playout plays random moves from the position, token to move, and returns
+// the winner or EmptyCell for a draw. PopOut games can go on forever, they
+// are called a draw after MaxBoardCells moves.
+func (m *MCTSPlayer) playout(board Position, token int) int {
+	var buf [2 * MaxBoardWidth]int
+	for moves := 0; moves < MaxBoardCells; moves++ {
+		actions := appendActions(buf[:0], board, token)
+		if len(actions) == 0 {
+			return EmptyCell
+		}
+
+		action := actions[m.rng.Intn(len(actions))]
+		if m.Settings.Heuristic {
+			action = m.heuristicAction(board, token, actions, action)
+		}
+
+		applyAction(&board, action, token)
+		if winner := moveWinner(board, token); winner != EmptyCell {
+			return winner
+		}
+		token = otherToken(token)
+	}
+	return EmptyCell
+}
+
+// heuristicAction returns a winning action if there is one, otherwise a drop
+// that blocks a win of the opponent, otherwise the random action
+func (m *MCTSPlayer) heuristicAction(board Position, token int, actions []int, random int) int {
+	for _, action := range actions {
+		boardCopy := board
+		applyAction(&boardCopy, action, token)
+		if moveWinner(boardCopy, token) == token {
+			return action
+		}
+	}
+	for col := 0; col < board.Width(); col++ {
+		if !board.CanPlay(col) {
+			continue
+		}
+		boardCopy := board
+		boardCopy.Play(col, otherToken(token))
+		if boardCopy.HasWon(otherToken(token)) {
+			return col
+		}
+	}
+	return random
+}
+
+// boardActions returns the legal actions of the token, see applyAction
+func boardActions(board Position, token int) []int {
+	return appendActions(nil, board, token)
+}
+
+// appendActions appends the legal actions of the token to actions
+func appendActions(actions []int, board Position, token int) []int {
+	width := board.Width()
+	for col := 0; col < width; col++ {
+		if board.CanPlay(col) {
+			actions = append(actions, col)
+		}
+	}
+	for col := 0; col < width; col++ {
+		if board.CanPop(col, token) {
+			actions = append(actions, width+col)
+		}
+	}
+	return actions
+}
+
+// applyAction plays a legal action for the token. Actions below the board
+// width drop a disc in that column, the next width actions pop one.
+func applyAction(board *Position, action, token int) {
+	if action < board.Width() {
+		board.Play(action, token)
+	} else {
+		board.Pop(action - board.Width())
+	}
+}
+
+// actionToMove converts an action into a move without a player
+func actionToMove(board Position, action int) Move {
+	if action >= board.Width() {
+		return Move{Column: action - board.Width(), Kind: MovePop}
+	}
+	return Move{Column: action, Kind: MoveDrop}
+}
@@ -0,0 +1,56 @@
+package games
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMCTSWinsAtOnce(t *testing.T) {
+	tests := []struct {
+		board string
+		want  int
+	}{
+		{"......./......./......./......./......./RRR.YYY R", 3},
+		{"......./......./......./Y....../Y..R.../Y.RR... Y", 0},
+		{"......./......./......./..RY.../.RYY.../RYYR..R R", 3},
+	}
+	for _, test := range tests {
+		pos, token, err := ParseBoard(test.board, DefaultRules())
+		if err != nil {
+			t.Fatal(err)
+		}
+		bot := NewMCTSPlayer()
+		bot.SetLevel(BotBeginner)
+		bot.Seed(1)
+		if move := bot.ChooseMove(pos, token, Budget{}); move.Column != test.want || move.Kind == MovePop {
+			t.Errorf("%s: got %+v, want a drop in column %d", test.board, move, test.want)
+		}
+	}
+}
+
+// TestMCTSBlocksLoss checks the search blocks the opponent's win with random
+// playouts, which don't look for threats themselves
+func TestMCTSBlocksLoss(t *testing.T) {
+	tests := []struct {
+		board string
+		want  int
+	}{
+		{"......./......./......./......./Y....../YRRR... Y", 4},
+		{"......./......./......./...R.../...R.Y./...R.Y. Y", 3},
+		{"......./......./......./..RY.../.RYY..R/RYYR..R Y", 3},
+	}
+	for _, test := range tests {
+		pos, token, err := ParseBoard(test.board, DefaultRules())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for seed := int64(1); seed <= 3; seed++ {
+			bot := NewMCTSPlayer()
+			bot.Settings = MCTSSettings{Iterations: 5000, TimeLimitMs: 60000, Exploration: math.Sqrt2}
+			bot.Seed(seed)
+			if move := bot.ChooseMove(pos, token, Budget{}); move.Column != test.want || move.Kind == MovePop {
+				t.Errorf("%s seed %d: got %+v, want a drop in column %d", test.board, seed, move, test.want)
+			}
+		}
+	}
+}
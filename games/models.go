@@ -1,40 +1,102 @@
 package games
 
 import (
+	"encoding/json"
 	"errors"
+	"sync"
 	"time"
 )
 type GameStatus string
 
 type GameType string
 
+// Outcome says how a finished game ended
+type Outcome string
+
 type Game struct {
 	ID           string    `json:"id"`
 	Type         GameType  `json:"type"`
-	Board        [][]int   `json:"board"`
+	Board        Position  `json:"board"`
+	Rules        Rules     `json:"rules"`
 	CurrentTurn  int       `json:"currentTurn"`
 	Player1ID    string    `json:"player1Id"`
-	Player2ID    string    `json:"player2Id"` // Could be "bot" for single player
+	Player2ID    string    `json:"player2Id"` // Could be "bot" or "bot:<strategy>" for single player
 	WinnerID     string    `json:"winnerId,omitempty"`
 	Status       GameStatus `json:"status"`
+	Outcome      Outcome   `json:"outcome,omitempty"`
+	WinningCells []Cell    `json:"winningCells,omitempty"` // Every disc of the winning line(s)
 	LastMoveTime time.Time `json:"lastMoveTime"`
 	CreatedAt    time.Time `json:"createdAt"`
-	Bot        *BotPlayer 
+	Moves        []MoveRecord `json:"moves"`
+	Setup        string    `json:"setup,omitempty"` // Board snapshot the game started from, if not empty
+	Clock        *Clock    `json:"clock,omitempty"` // Nil for untimed games
+	DrawOfferBy  string    `json:"drawOfferBy,omitempty"` // Player with an open draw offer
+	BotLevel     BotLevel  `json:"botLevel,omitempty"` // Difficulty of the bot, empty without one or if the bots' differ
+	SeatBotLevels *SeatLevels `json:"seatBotLevels,omitempty"` // Difficulty of each color's bot, once one was set on its own
+	BotProfile   string    `json:"botProfile,omitempty"` // Evaluation profile of the bot, empty for the default
+
+	bots         [3]Strategy   // Bot of each token, nil for a person, see BotFor
+	botTurn      *BotTurn      // Move the bot is thinking about, see StartBotTurn
+	botMu        sync.Mutex    // Held while a bot thinks, see BotTurn.Choose
+	version      int           // Counts moves, takebacks and resets, see Version
+	positions    []positionKey // Positions after every move, for the repetition draw
+	drawOfferPly int           // Number of moves when the draw was offered
+	mu        sync.Mutex    // Held while a game is changed, see Lock
+}
+
+// Lock serializes changes to the game between request handlers and server timers
+func (g *Game) Lock() {
+	g.mu.Lock()
+}
+
+// Unlock releases the lock taken by Lock
+func (g *Game) Unlock() {
+	g.mu.Unlock()
+}
+
+// positionKey identifies a board together with the player to move
+type positionKey struct {
+	Board uint64
+	Turn  int
 }
 
+// MoveKind says whether a move drops a disc or pops one out
+type MoveKind string
+
 type Player struct {
 	ID       string `json:"id"`
 	Username string `json:"username"`
 	Wins     int    `json:"wins"`
 	Losses   int    `json:"losses"`
+	Draws    int    `json:"draws"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
 // func CreatePlayer()
 
 type Move struct {
-	PlayerID string `json:"playerId"`
-	Column   int    `json:"column"`
+	PlayerID string   `json:"playerId"`
+	Column   int      `json:"column"`
+	Kind     MoveKind `json:"kind,omitempty"` // MoveDrop if empty
+}
+
+// Cell is a board coordinate, row 0 is the top row
+type Cell struct {
+	Row    int `json:"row"`
+	Column int `json:"column"`
+}
+
+// MoveRecord is one entry of a game's move history
+type MoveRecord struct {
+	Ply         int       `json:"ply"` // 1 for the first move of the game
+	PlayerID    string    `json:"playerId"`
+	Token       int       `json:"token"`
+	Kind        MoveKind  `json:"kind"`
+	Column      int       `json:"column"`
+	Row         int       `json:"row"` // Row the disc landed on or was popped from, 0 is the top row
+	Timestamp   time.Time `json:"timestamp"`
+	ThinkTimeMs int64     `json:"thinkTimeMs"` // Time since the previous move
+	Search      *SearchInfo `json:"-"`         // How the bot found the move, nil for a person's, see BotSearches
 }
 
 
@@ -44,49 +106,139 @@ func NewPlayer(Username string) * Player {
 		Username:  Username,
 		Wins:      0,
 		Losses:    0,
+		Draws:     0,
 		CreatedAt: time.Now(),
 	}
 	
 }
 
-func NewBoard() [][]int {
-	board := make([][]int, BoardHeight)
-	for i := range board {
-		board[i] = make([]int, BoardWidth)
-	}
-	return board
+// NewBoard returns an empty board for the rules
+func NewBoard(rules Rules) Position {
+	return NewPosition(rules)
 }
-// NewGame creates a new game with an empty board
-func NewGame(gameType GameType, player1ID, player2ID string) *Game {
+// NewGame creates a new game with an empty board.
+// The rules must already be validated, see Rules.Validate.
+func NewGame(gameType GameType, player1ID, player2ID string, rules Rules) *Game {
 	// Initialize empty board
-	board := NewBoard()
+	board := NewBoard(rules)
 
 	game := &Game{
 		ID:          generateGameID(),
 		Type:        gameType,
 		Board:       board,
+		Rules:       rules,
 		CurrentTurn: RedToken, // Red always starts
 		Player1ID:   player1ID,
 		Player2ID:   player2ID,
 		Status:      StatusWaiting,
 		CreatedAt:   time.Now(),
+		Moves:       []MoveRecord{},
 		
 	}
-	// Initialize a bot if one of the players is a bot
-    if player1ID == "bot" {
-        game.Bot = NewBotPlayer(player1ID, RedToken)
-    } else if player2ID == "bot" {
-        game.Bot = NewBotPlayer(player2ID, YellowToken)
-    }
+	game.recordPosition(game.CurrentTurn)
+
+	// Initialize a bot for every player that is a bot
+	game.seatBots()
 
 	return game
 }
 
+// UnmarshalJSON reads a game's JSON. The board's grid doesn't say how many
+// discs in a line win or which variant is played, so it is read with the
+// game's rules.
+func (g *Game) UnmarshalJSON(data []byte) error {
+	type plainGame Game // Without this method, so it doesn't call itself
+	aux := struct {
+		*plainGame
+		Board json.RawMessage `json:"board"`
+	}{plainGame: (*plainGame)(g)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	g.Board = NewPosition(g.Rules.WithDefaults())
+	if len(aux.Board) > 0 {
+		return json.Unmarshal(aux.Board, &g.Board)
+	}
+	return nil
+}
+
+// ApplyMove plays a move of any kind
+func (g *Game) ApplyMove(move Move) error {
+	switch move.Kind {
+	case MoveDrop, "":
+		return g.MakeMove(move.PlayerID, move.Column)
+	case MovePop:
+		return g.PopOut(move.PlayerID, move.Column)
+	}
+	return errors.New("invalid move kind")
+}
+
 // MakeMove attempts to drop a token in the specified column
 func (g *Game) MakeMove(playerID string, column int) error {
+	playerToken, err := g.turnToken(playerID)
+	if err != nil {
+		return err
+	}
+	return g.drop(playerID, playerToken, column)
+}
+
+// drop plays a drop for the token whose turn it is
+func (g *Game) drop(playerID string, playerToken, column int) error {
+	// Check if column is valid
+	if column < 0 || column >= g.Board.Width() {
+		return errors.New("invalid column")
+	}
+	
+	if !g.Board.CanPlay(column) {
+		return errors.New("column is full")
+	}
+	
+	// Place the token
+	row := g.Board.Play(column, playerToken)
+	g.recordMove(playerID, playerToken, MoveDrop, column, row)
+	g.finishMove(playerToken)
+	
+	return nil
+}
+
+// PopOut removes one of the player's own discs from the bottom of a column,
+// the rest of the column shifts down. Only allowed in PopOut games.
+func (g *Game) PopOut(playerID string, column int) error {
+	playerToken, err := g.turnToken(playerID)
+	if err != nil {
+		return err
+	}
+	return g.pop(playerID, playerToken, column)
+}
+
+// pop plays a pop for the token whose turn it is
+func (g *Game) pop(playerID string, playerToken, column int) error {
+	if g.Rules.Variant != VariantPopOut {
+		return errors.New("popping discs is only allowed in PopOut games")
+	}
+	
+	// Check if column is valid
+	if column < 0 || column >= g.Board.Width() {
+		return errors.New("invalid column")
+	}
+	
+	if !g.Board.CanPop(column, playerToken) {
+		return errors.New("you can only pop your own disc from the bottom row")
+	}
+	
+	g.Board.Pop(column)
+	g.recordMove(playerID, playerToken, MovePop, column, g.Board.Height()-1)
+	g.finishMove(playerToken)
+	
+	return nil
+}
+
+// turnToken checks that the player may move now and returns their token
+func (g *Game) turnToken(playerID string) (int, error) {
 	// Check if it's this player's turn
 	if g.Status != StatusActive {
-		return errors.New("game is not active")
+		return 0, errors.New("game is not active")
 	}
 	
 	// Determine which token this player uses
@@ -96,113 +248,256 @@ func (g *Game) MakeMove(playerID string, column int) error {
 	} else if playerID == g.Player2ID {
 		playerToken = YellowToken
 	} else {
-		return errors.New("player is not in this game")
+		return 0, errors.New("player is not in this game")
 	}
 	
 	// Check if it's this player's turn
 	if playerToken != g.CurrentTurn {
-		return errors.New("not your turn")
+		return 0, errors.New("not your turn")
 	}
 	
-	// Check if column is valid
-	if column < 0 || column >= BoardWidth {
-		return errors.New("invalid column")
+	// Nobody may move for the bot, it moves by itself
+	if g.BotFor(playerToken) != nil {
+		return 0, errors.New("the bot makes its own moves")
 	}
 	
-	// Find the bottom-most empty cell in the column
-	row := -1
-	for r := BoardHeight - 1; r >= 0; r-- {
-		if g.Board[r][column] == EmptyCell {
-			row = r
-			break
-		}
+	// The server's timer ends the game once the flag falls
+	if g.Clock != nil && g.Clock.Remaining(playerToken, time.Now()) <= 0 {
+		return 0, errors.New("out of time")
 	}
 	
-	if row == -1 {
-		return errors.New("column is full")
+	return playerToken, nil
+}
+
+// finishMove checks whether the move just played ended the game and passes the turn
+func (g *Game) finishMove(playerToken int) {
+	opponentToken := otherToken(playerToken)
+	
+	// Charge the mover's clock and start the opponent's
+	if g.Clock != nil {
+		g.Clock.Press(time.Now())
 	}
 	
-	// Place the token
-	g.Board[row][column] = playerToken
-	
-	// Check for win condition
-	if g.checkWinCondition(row, column, playerToken) {
-		g.Status = StatusFinished
-		if playerToken == RedToken {
-			g.WinnerID = g.Player1ID
-		} else {
-			g.WinnerID = g.Player2ID
-		}
-		return nil
+	// A draw offer lapses with the offering player's next move. An offer made
+	// on their own turn goes with the move they make, like over the board.
+	if g.DrawOfferBy != "" && g.DrawOfferBy == g.playerID(playerToken) && len(g.Moves) > g.drawOfferPly+1 {
+		g.DrawOfferBy = ""
 	}
 	
-	// Check for draw
-	if g.isBoardFull() {
-		g.Status = StatusFinished
-		return nil
+	// Check for win condition. A pop can complete lines for both players
+	// at once, then the player who popped wins.
+	if g.checkWinCondition(playerToken) {
+		g.finish(OutcomeWin, playerToken)
+		g.WinningCells = g.Board.WinningCells(playerToken)
+		return
+	}
+	if g.checkWinCondition(opponentToken) {
+		g.finish(OutcomeWin, opponentToken)
+		g.WinningCells = g.Board.WinningCells(opponentToken)
+		return
 	}
 	
-	// Switch turns
-	if g.CurrentTurn == RedToken {
-		g.CurrentTurn = YellowToken
-	} else {
-		g.CurrentTurn = RedToken
+	// Check for draw, a full board is only a draw if the next player can't pop either
+	if !g.Board.HasLegalMove(opponentToken) {
+		g.finish(OutcomeDraw, EmptyCell)
+		return
+	}
+	
+	// The same position with the same player to move for the third time is a draw
+	if g.recordPosition(opponentToken) >= 3 {
+		g.finish(OutcomeDraw, EmptyCell)
+		return
 	}
 	
+	// Switch turns
+	g.CurrentTurn = opponentToken
+	
+	g.LastMoveTime = time.Now()
+}
+
+// Reset clears the board and move history and starts a new round with the given token to move
+func (g *Game) Reset(startingToken int) {
+	g.Board = NewBoard(g.Rules)
+	g.CurrentTurn = startingToken
+	g.WinnerID = ""
+	g.Outcome = ""
+	g.WinningCells = nil
 	g.LastMoveTime = time.Now()
+	g.Moves = []MoveRecord{}
+	g.Setup = ""
+	g.DrawOfferBy = ""
+	g.positions = nil
+	g.version++
+	g.recordPosition(startingToken)
 	
-	return nil
+	// Both players get their full time back
+	if g.Clock != nil {
+		g.Clock = NewClock(g.Clock.TimeControl)
+	}
+	g.Start()
 }
 
-// isBoardFull checks if the board is completely filled
-func (g *Game) isBoardFull() bool {
-	for col := 0; col < BoardWidth; col++ {
-		if g.Board[0][col] == EmptyCell {
-			return false
-		}
+// finish ends the game, winnerToken is EmptyCell when nobody won
+func (g *Game) finish(outcome Outcome, winnerToken int) {
+	if g.Clock != nil {
+		g.Clock.Stop(time.Now())
+	}
+	g.Status = StatusFinished
+	g.Outcome = outcome
+	switch winnerToken {
+	case RedToken:
+		g.WinnerID = g.Player1ID
+	case YellowToken:
+		g.WinnerID = g.Player2ID
+	default:
+		g.WinnerID = ""
 	}
-	return true
+	g.releaseBots()
 }
 
-// checkWinCondition checks if the last move resulted in a win
-func (g *Game) checkWinCondition(row, col, playerToken int) bool {
-	// Check horizontal
-	if g.countConsecutive(row, col, 0, 1, playerToken) + g.countConsecutive(row, col, 0, -1, playerToken) - 1 >= 4 {
-		return true
+// Abandon ends an unfinished game without a winner
+func (g *Game) Abandon() error {
+	if g.Status == StatusFinished {
+		return errors.New("game is over")
 	}
-	
-	// Check vertical
-	if g.countConsecutive(row, col, 1, 0, playerToken) + g.countConsecutive(row, col, -1, 0, playerToken) - 1 >= 4 {
-		return true
+	g.finish(OutcomeAbandonment, EmptyCell)
+	return nil
+}
+
+// Version identifies the game's position in its history. It changes with
+// every move, takeback and reset, so a request made in one position can be
+// told apart from the same request in a later one.
+func (g *Game) Version() int {
+	return g.version
+}
+
+// Rated reports whether the result of the game counts in the players' stats,
+// games against a bot don't count
+func (g *Game) Rated() bool {
+	return !IsBotID(g.Player1ID) && !IsBotID(g.Player2ID)
+}
+
+// UndoMove takes back the last move and gives the turn back to the player who made it
+func (g *Game) UndoMove() error {
+	if len(g.Moves) == 0 {
+		return errors.New("no moves to undo")
 	}
-	
-	// Check diagonal (/)
-	if g.countConsecutive(row, col, -1, 1, playerToken) + g.countConsecutive(row, col, 1, -1, playerToken) - 1 >= 4 {
-		return true
+	// Only a game ended by the last move itself can be brought back, and
+	// only if its result wasn't counted in the players' stats
+	if g.Status == StatusFinished && (g.Outcome != OutcomeWin && g.Outcome != OutcomeDraw || g.Rated()) {
+		return errors.New("game is over")
 	}
-	
-	// Check diagonal (\)
-	if g.countConsecutive(row, col, -1, -1, playerToken) + g.countConsecutive(row, col, 1, 1, playerToken) - 1 >= 4 {
-		return true
+
+	last := g.Moves[len(g.Moves)-1]
+	if last.Kind == MovePop {
+		g.Board.Unpop(last.Column, last.Token)
+	} else {
+		g.Board.Undo(last.Column)
+	}
+	g.Moves = g.Moves[:len(g.Moves)-1]
+	g.version++
+	if len(g.positions) > len(g.Moves)+1 {
+		g.positions = g.positions[:len(g.Moves)+1]
 	}
+
+	// Whatever the move did to the game is rolled back with it
+	g.CurrentTurn = last.Token
+	g.Status = StatusActive
+	g.WinnerID = ""
+	g.Outcome = ""
+	g.WinningCells = nil
+	g.DrawOfferBy = ""
+	g.LastMoveTime = time.Now()
 	
-	return false
+	// The time already used stays used, the clock runs for the player to move again
+	if g.Clock != nil {
+		g.Clock.Stop(g.LastMoveTime)
+		g.Clock.Start(g.CurrentTurn, g.LastMoveTime)
+	}
+	return nil
+}
+
+// TakeBack undoes the last move. Against the bot the bot's reply is taken
+// back as well, so it is the human player's turn again.
+func (g *Game) TakeBack() error {
+	if !g.HasBot() {
+		return g.UndoMove()
+	}
+
+	// Find the last move made by the human player
+	last := len(g.Moves) - 1
+	for last >= 0 && g.BotFor(g.Moves[last].Token) != nil {
+		last--
+	}
+	if last < 0 {
+		return errors.New("no moves to undo")
+	}
+
+	for len(g.Moves) > last {
+		if err := g.UndoMove(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordMove appends a move to the history, think time is measured from the previous move
+func (g *Game) recordMove(playerID string, token int, kind MoveKind, column, row int) {
+	now := time.Now()
+	since := g.LastMoveTime
+	if since.IsZero() {
+		since = g.CreatedAt
+	}
+
+	g.version++
+	g.Moves = append(g.Moves, MoveRecord{
+		Ply:         len(g.Moves) + 1,
+		PlayerID:    playerID,
+		Token:       token,
+		Kind:        kind,
+		Column:      column,
+		Row:         row,
+		Timestamp:   now,
+		ThinkTimeMs: now.Sub(since).Milliseconds(),
+	})
 }
 
-// countConsecutive counts consecutive tokens in a direction
-func (g *Game) countConsecutive(row, col, rowDelta, colDelta, playerToken int) int {
+// recordPosition adds the current board with the token to move to the
+// position history and returns how often that position has occurred
+func (g *Game) recordPosition(tokenToMove int) int {
+	key := positionKey{Board: g.Board.Key(), Turn: tokenToMove}
+	g.positions = append(g.positions, key)
+
 	count := 0
-	r, c := row, col
-	
-	for r >= 0 && r < BoardHeight && c >= 0 && c < BoardWidth && g.Board[r][c] == playerToken {
-		count++
-		r += rowDelta
-		c += colDelta
+	for _, seen := range g.positions {
+		if seen == key {
+			count++
+		}
 	}
-	
 	return count
 }
 
+// checkWinCondition checks if the last move resulted in a win
+func (g *Game) checkWinCondition(playerToken int) bool {
+	return g.Board.HasWon(playerToken)
+}
+
+// playerID returns the ID of the player with the token
+func (g *Game) playerID(token int) string {
+	if token == RedToken {
+		return g.Player1ID
+	}
+	return g.Player2ID
+}
+
+// otherToken returns the token of the other player
+func otherToken(token int) int {
+	if token == RedToken {
+		return YellowToken
+	}
+	return RedToken
+}
+
 // Helper functions
 func generateGameID() string {
 	// In a real implementation, use a proper UUID library
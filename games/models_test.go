@@ -0,0 +1,115 @@
+package games
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// playColumns drops a disc in each column for whoever is to move, bots included
+func playColumns(t *testing.T, g *Game, columns ...int) {
+	t.Helper()
+	for _, col := range columns {
+		token := g.CurrentTurn
+		if err := g.drop(g.playerID(token), token, col); err != nil {
+			t.Fatalf("column %d: %v", col, err)
+		}
+	}
+}
+
+func TestTakeBack(t *testing.T) {
+	redWins := []int{0, 6, 1, 6, 2, 6, 3}
+	tests := []struct {
+		name      string
+		player2   string
+		columns   []int
+		resign    bool
+		undo      func(*Game) error
+		wantMoves int // -1 if the takeback is refused
+	}{
+		{"local game takes back one move", "bob", []int{3, 3, 2}, false, (*Game).TakeBack, 2},
+		{"bot's reply goes with the player's move", "bot", []int{3, 3, 2, 2}, false, (*Game).TakeBack, 2},
+		{"UndoMove takes back the bot's reply only", "bot", []int{3, 3, 2, 2}, false, (*Game).UndoMove, 3},
+		{"won game against the bot", "bot", redWins, false, (*Game).TakeBack, 6},
+		{"the bot's win", "bot", []int{0, 6, 1, 6, 2, 6, 0, 6}, false, (*Game).TakeBack, 6},
+		{"won rated game is refused", "bob", redWins, false, (*Game).TakeBack, -1},
+		{"resigned game is refused", "bot", []int{3, 3}, true, (*Game).TakeBack, -1},
+		{"no moves", "bot", nil, false, (*Game).TakeBack, -1},
+	}
+	for _, test := range tests {
+		gameType := LocalMultiplayer
+		if IsBotID(test.player2) {
+			gameType = SinglePlayer
+		}
+		t.Run(test.name, func(t *testing.T) {
+			game := NewGame(gameType, "alice", test.player2, DefaultRules())
+			game.SetTimeControl(&TimeControl{InitialMs: 60000, IncrementMs: 1000})
+			game.Start()
+			playColumns(t, game, test.columns...)
+			if test.resign {
+				if err := game.Resign("alice"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			status, moves := game.Status, len(game.Moves)
+
+			err := test.undo(game)
+			if test.wantMoves == -1 {
+				if err == nil {
+					t.Fatal("the takeback was allowed")
+				}
+				if game.Status != status || len(game.Moves) != moves {
+					t.Errorf("a refused takeback changed the game to %s with %d moves", game.Status, len(game.Moves))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The game is as if only the moves left were played
+			want := NewGame(gameType, "alice", test.player2, DefaultRules())
+			want.Start()
+			playColumns(t, want, test.columns[:test.wantMoves]...)
+			if len(game.Moves) != test.wantMoves || game.Board != want.Board || game.CurrentTurn != want.CurrentTurn {
+				t.Errorf("got %d moves with %d to move, want %d with %d", len(game.Moves), game.CurrentTurn, test.wantMoves, want.CurrentTurn)
+			}
+			if game.Status != StatusActive || game.WinnerID != "" || game.Outcome != "" || game.WinningCells != nil {
+				t.Errorf("the result stayed: %s %q %q %v", game.Status, game.WinnerID, game.Outcome, game.WinningCells)
+			}
+			if game.Clock.Running() != game.CurrentTurn {
+				t.Errorf("clock of %d running, want %d", game.Clock.Running(), game.CurrentTurn)
+			}
+		})
+	}
+}
+
+// TestGameJSONRoundTrip checks that a game's board comes back with the
+// game's connect length and variant, which its grid doesn't hold
+func TestGameJSONRoundTrip(t *testing.T) {
+	popOut := DefaultRules()
+	popOut.Variant = VariantPopOut
+	tests := []struct {
+		rules   Rules
+		columns []int
+	}{
+		{Rules{Width: 8, Height: 7, Connect: 5, Variant: VariantStandard}, []int{0, 7, 1, 7, 2, 7, 3}},
+		{popOut, []int{3, 3, 2}},
+	}
+	for _, test := range tests {
+		game := NewGame(LocalMultiplayer, "alice", "bob", test.rules)
+		game.Start()
+		playColumns(t, game, test.columns...)
+
+		data, err := json.Marshal(game)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Game
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Board != game.Board || got.Rules != game.Rules || len(got.Moves) != len(game.Moves) {
+			t.Errorf("%+v: the game came back with rules %+v and board %v", test.rules, got.Board.Rules(), got.Board.Grid())
+		}
+	}
+}
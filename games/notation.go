@@ -0,0 +1,244 @@
+package games
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Notation is a game written down as text.
+//
+// Moves is the common 1-indexed column sequence, e.g. "4453". In PopOut
+// games a column prefixed with 'p' pops a disc, e.g. "44p4". Board is a
+// snapshot of the current position, see FormatBoard. Setup is the snapshot
+// the game was started from, if it didn't start from an empty board.
+type Notation struct {
+	Setup string `json:"setup,omitempty"`
+	Moves string `json:"moves"`
+	Board string `json:"board"`
+}
+
+// maxNotationWidth is the widest board a single digit per column can describe
+const maxNotationWidth = 9
+
+// ParseMoveSequence parses a column sequence into moves.
+// Every move is checked for legality by replaying it from the empty board.
+func ParseMoveSequence(notation string, rules Rules) ([]Move, error) {
+	if rules.Width > maxNotationWidth {
+		return nil, fmt.Errorf("move notation only supports boards up to %d columns", maxNotationWidth)
+	}
+
+	moves := []Move{}
+	kind := MoveDrop
+	for _, ch := range notation {
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			continue
+		case ch == 'p' || ch == 'P':
+			if kind == MovePop {
+				return nil, errors.New("pop marker must be followed by a column")
+			}
+			kind = MovePop
+		case ch >= '1' && ch <= '9':
+			moves = append(moves, Move{Column: int(ch - '1'), Kind: kind})
+			kind = MoveDrop
+		default:
+			return nil, fmt.Errorf("invalid character %q in move notation", ch)
+		}
+	}
+	if kind == MovePop {
+		return nil, errors.New("pop marker must be followed by a column")
+	}
+
+	// Replay on a scratch game to check every move
+	game := NewGame(LocalMultiplayer, "", "", rules)
+	if err := game.replay(moves); err != nil {
+		return nil, err
+	}
+	return moves, nil
+}
+
+// FormatMoveSequence writes the game's moves as a column sequence
+func FormatMoveSequence(g *Game) (string, error) {
+	if g.Rules.Width > maxNotationWidth {
+		return "", fmt.Errorf("move notation only supports boards up to %d columns", maxNotationWidth)
+	}
+
+	var sb strings.Builder
+	for _, move := range g.Moves {
+		if move.Kind == MovePop {
+			sb.WriteByte('p')
+		}
+		sb.WriteByte(byte('1' + move.Column))
+	}
+	return sb.String(), nil
+}
+
+// FormatBoard writes a board snapshot: the rows from top to bottom separated
+// by '/', with '.' for an empty cell and 'R' or 'Y' for a disc, followed by a
+// space and the player to move, e.g. "......./......./...R... Y" on a small board
+func FormatBoard(pos Position, tokenToMove int) string {
+	var sb strings.Builder
+	for row := 0; row < pos.Height(); row++ {
+		if row > 0 {
+			sb.WriteByte('/')
+		}
+		for col := 0; col < pos.Width(); col++ {
+			sb.WriteByte(tokenLetter(pos.Cell(row, col)))
+		}
+	}
+	sb.WriteByte(' ')
+	sb.WriteByte(tokenLetter(tokenToMove))
+	return sb.String()
+}
+
+// ParseBoard reads a board snapshot written by FormatBoard and returns the
+// position and the player to move. The position must be reachable, with no
+// floating discs and nobody having won yet.
+func ParseBoard(snapshot string, rules Rules) (Position, int, error) {
+	fields := strings.Fields(snapshot)
+	if len(fields) != 2 || len(fields[1]) != 1 {
+		return Position{}, 0, errors.New("board snapshot must be rows followed by the player to move")
+	}
+
+	tokenToMove := letterToken(fields[1][0])
+	if tokenToMove == EmptyCell {
+		return Position{}, 0, errors.New("player to move must be R or Y")
+	}
+
+	rows := strings.Split(fields[0], "/")
+	grid := make([][]int, len(rows))
+	redCount, yellowCount := 0, 0
+	for r, row := range rows {
+		grid[r] = make([]int, len(row))
+		for c := 0; c < len(row); c++ {
+			if row[c] == '.' {
+				continue
+			}
+			token := letterToken(row[c])
+			if token == EmptyCell {
+				return Position{}, 0, fmt.Errorf("invalid cell %q in board snapshot", row[c])
+			}
+			if token == RedToken {
+				redCount++
+			} else {
+				yellowCount++
+			}
+			grid[r][c] = token
+		}
+	}
+
+	pos, err := PositionFromGrid(grid, rules)
+	if err != nil {
+		return Position{}, 0, err
+	}
+
+	// Players take turns dropping discs, pops in PopOut games break the count
+	if rules.Variant != VariantPopOut {
+		diff := redCount - yellowCount
+		if diff > 1 || diff < -1 {
+			return Position{}, 0, errors.New("disc counts are not reachable by taking turns")
+		}
+		if (diff == 1 && tokenToMove != YellowToken) || (diff == -1 && tokenToMove != RedToken) {
+			return Position{}, 0, errors.New("wrong player to move for the disc counts")
+		}
+	}
+	if pos.HasWon(RedToken) || pos.HasWon(YellowToken) {
+		return Position{}, 0, errors.New("position is already won")
+	}
+	if !pos.HasLegalMove(tokenToMove) {
+		return Position{}, 0, errors.New("player to move has no legal move")
+	}
+
+	return pos, tokenToMove, nil
+}
+
+// NewGameFromNotation creates a game from either a move sequence or a board
+// snapshot. The game is left waiting like NewGame, unless the moves finish it.
+func NewGameFromNotation(gameType GameType, player1ID, player2ID string, rules Rules, notation string) (*Game, error) {
+	game := NewGame(gameType, player1ID, player2ID, rules)
+
+	if strings.Contains(notation, "/") {
+		pos, tokenToMove, err := ParseBoard(notation, rules)
+		if err != nil {
+			return nil, err
+		}
+		game.Board = pos
+		game.CurrentTurn = tokenToMove
+		game.Setup = FormatBoard(pos, tokenToMove)
+		game.positions = nil
+		game.recordPosition(tokenToMove)
+		return game, nil
+	}
+
+	moves, err := ParseMoveSequence(notation, rules)
+	if err != nil {
+		return nil, err
+	}
+	if err := game.replay(moves); err != nil {
+		return nil, err
+	}
+	if game.Status == StatusActive {
+		game.Status = StatusWaiting
+	}
+	return game, nil
+}
+
+// Notation writes the game down as text
+func (g *Game) Notation() (Notation, error) {
+	moves, err := FormatMoveSequence(g)
+	if err != nil {
+		return Notation{}, err
+	}
+	return Notation{
+		Setup: g.Setup,
+		Moves: moves,
+		Board: FormatBoard(g.Board, g.CurrentTurn),
+	}, nil
+}
+
+// replay plays moves in order for whoever is to move
+func (g *Game) replay(moves []Move) error {
+	g.Status = StatusActive
+	for i, move := range moves {
+		if g.Status != StatusActive {
+			return fmt.Errorf("move %d is played after the game ended", i+1)
+		}
+
+		token := g.CurrentTurn
+		playerID := g.playerID(token)
+
+		var err error
+		if move.Kind == MovePop {
+			err = g.pop(playerID, token, move.Column)
+		} else {
+			err = g.drop(playerID, token, move.Column)
+		}
+		if err != nil {
+			return fmt.Errorf("move %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// tokenLetter returns the snapshot letter of a token
+func tokenLetter(token int) byte {
+	switch token {
+	case RedToken:
+		return 'R'
+	case YellowToken:
+		return 'Y'
+	}
+	return '.'
+}
+
+// letterToken returns the token of a snapshot letter, EmptyCell if it isn't one
+func letterToken(letter byte) int {
+	switch letter {
+	case 'R', 'r':
+		return RedToken
+	case 'Y', 'y':
+		return YellowToken
+	}
+	return EmptyCell
+}
@@ -0,0 +1,106 @@
+package games
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMoveSequenceRoundTrip(t *testing.T) {
+	popOut := DefaultRules()
+	popOut.Variant = VariantPopOut
+	tests := []struct {
+		moves string
+		rules Rules
+	}{
+		{"", DefaultRules()},
+		{"4453", DefaultRules()},
+		{"1212121", DefaultRules()}, // Red wins with the last move
+		{"44p4", popOut},
+	}
+	for _, test := range tests {
+		game, err := NewGameFromNotation(LocalMultiplayer, "alice", "bob", test.rules, test.moves)
+		if err != nil {
+			t.Fatalf("%s: %v", test.moves, err)
+		}
+		if got, err := FormatMoveSequence(game); err != nil || got != test.moves {
+			t.Errorf("%s came back as %q (%v)", test.moves, got, err)
+		}
+	}
+}
+
+func TestParseMoveSequenceErrors(t *testing.T) {
+	popOut := DefaultRules()
+	popOut.Variant = VariantPopOut
+	tests := []struct {
+		name  string
+		moves string
+		rules Rules
+	}{
+		{"invalid character", "44x3", DefaultRules()},
+		{"column zero", "404", DefaultRules()},
+		{"column past the board", "448", DefaultRules()},
+		{"full column", "4444444", DefaultRules()},
+		{"move after a win", "12121213", DefaultRules()},
+		{"pop in a standard game", "44p4", DefaultRules()},
+		{"pop of the opponent's disc", "4p4", popOut},
+		{"pop marker without a column", "44p", popOut},
+		{"board too wide", "1", Rules{Width: 10, Height: 5, Connect: 4, Variant: VariantStandard}},
+	}
+	for _, test := range tests {
+		if _, err := ParseMoveSequence(test.moves, test.rules); err == nil {
+			t.Errorf("%s: %s was accepted", test.name, test.moves)
+		}
+	}
+}
+
+func TestBoardSnapshotRoundTrip(t *testing.T) {
+	wide := Rules{Width: 8, Height: 7, Connect: 5, Variant: VariantStandard}
+	tests := []struct {
+		snapshot string
+		rules    Rules
+	}{
+		{"......./......./......./......./......./....... R", DefaultRules()},
+		{"......./......./......./......./...Y.../..RR... Y", DefaultRules()},
+		{".YRRRY./RRYYYR./YYYRYYR/RRRYYYR/RYRRRYR/RYYRYRY Y", DefaultRules()},
+		{"......../......../......../....Y.../....R.../...YR.../..RYRY.. R", wide},
+	}
+	for _, test := range tests {
+		pos, token, err := ParseBoard(test.snapshot, test.rules)
+		if err != nil {
+			t.Fatalf("%s: %v", test.snapshot, err)
+		}
+		if got := FormatBoard(pos, token); got != test.snapshot {
+			t.Errorf("snapshot %s came back as %s", test.snapshot, got)
+		}
+
+		data, err := json.Marshal(pos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fromJSON := NewPosition(test.rules)
+		if err := json.Unmarshal(data, &fromJSON); err != nil {
+			t.Fatal(err)
+		}
+		if fromJSON != pos {
+			t.Errorf("%s: the JSON gives a different position", test.snapshot)
+		}
+	}
+}
+
+func TestParseBoardErrors(t *testing.T) {
+	tests := map[string]string{
+		"floating disc":     "......./......./......./...R.../......./....... Y",
+		"wrong counts":      "......./......./......./......./......./RRR.... Y",
+		"wrong player":      "......./......./......./......./......./...R... R",
+		"already won":       "......./......./......./......./YYY..../RRRR... Y",
+		"wrong width":       "....../....../....../....../....../...... R",
+		"invalid cell":      "......./......./......./......./......./...X... R",
+		"no player to move": "......./......./......./......./......./.......",
+		"invalid player":    "......./......./......./......./......./....... X",
+	}
+	for name, snapshot := range tests {
+		if _, _, err := ParseBoard(snapshot, DefaultRules()); err == nil {
+			t.Errorf("%s: %s was accepted", name, snapshot)
+		}
+	}
+}
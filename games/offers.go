@@ -0,0 +1,87 @@
+package games
+
+import "errors"
+
+// Resign ends the game as a loss for the player
+func (g *Game) Resign(playerID string) error {
+	token, err := g.activePlayerToken(playerID)
+	if err != nil {
+		return err
+	}
+
+	g.finish(OutcomeResignation, otherToken(token))
+	g.DrawOfferBy = ""
+	return nil
+}
+
+// OfferDraw offers the opponent a draw. The offer stays open until the
+// opponent answers or the offering player makes their next move, an offer
+// made on the player's own turn is attached to the move they are about to make.
+// Offering a draw while the opponent's offer is open accepts it.
+func (g *Game) OfferDraw(playerID string) error {
+	token, err := g.activePlayerToken(playerID)
+	if err != nil {
+		return err
+	}
+	if g.HasBot() {
+		return errors.New("the bot doesn't accept draw offers")
+	}
+
+	if g.DrawOfferBy == g.playerID(otherToken(token)) {
+		return g.AcceptDraw(playerID)
+	}
+
+	g.DrawOfferBy = playerID
+	g.drawOfferPly = len(g.Moves)
+	return nil
+}
+
+// AcceptDraw ends the game as a draw if the opponent offered one
+func (g *Game) AcceptDraw(playerID string) error {
+	token, err := g.activePlayerToken(playerID)
+	if err != nil {
+		return err
+	}
+	if g.DrawOfferBy == "" || g.DrawOfferBy != g.playerID(otherToken(token)) {
+		return errors.New("there is no draw offer to accept")
+	}
+
+	g.finish(OutcomeDraw, EmptyCell)
+	g.DrawOfferBy = ""
+	return nil
+}
+
+// DeclineDraw rejects the opponent's draw offer
+func (g *Game) DeclineDraw(playerID string) error {
+	token, err := g.activePlayerToken(playerID)
+	if err != nil {
+		return err
+	}
+	if g.DrawOfferBy == "" || g.DrawOfferBy != g.playerID(otherToken(token)) {
+		return errors.New("there is no draw offer to decline")
+	}
+
+	g.DrawOfferBy = ""
+	return nil
+}
+
+// activePlayerToken returns the token of a player in an active game, whoever's turn it is
+func (g *Game) activePlayerToken(playerID string) (int, error) {
+	if g.Status != StatusActive {
+		return 0, errors.New("game is not active")
+	}
+	var token int
+	switch playerID {
+	case g.Player1ID:
+		token = RedToken
+	case g.Player2ID:
+		token = YellowToken
+	default:
+		return 0, errors.New("player is not in this game")
+	}
+	// Nobody may resign or agree to a draw for the bot
+	if g.BotFor(token) != nil {
+		return 0, errors.New("the bot makes its own decisions")
+	}
+	return token, nil
+}
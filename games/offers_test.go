@@ -0,0 +1,82 @@
+package games
+
+import "testing"
+
+func TestDrawOffer(t *testing.T) {
+	tests := []struct {
+		name    string
+		offerBy string
+		before  []int  // Moves before the offer
+		after   []int  // Moves after the offer
+		decline bool   // bob declines before accepting
+		accepts string // Player accepting
+		want    bool   // Whether the draw is accepted
+	}{
+		{"accepted at once", "alice", nil, nil, false, "bob", true},
+		{"offer goes with the offering player's move", "alice", nil, []int{3}, false, "bob", true},
+		{"offer made on the opponent's turn", "alice", []int{3}, []int{3}, false, "bob", true},
+		{"offer lapses with the next move", "alice", nil, []int{3, 3, 2}, false, "bob", false},
+		{"offer made on the opponent's turn lapses", "alice", []int{3}, []int{3, 2}, false, "bob", false},
+		{"own offer", "alice", nil, nil, false, "alice", false},
+		{"declined offer", "alice", nil, nil, true, "bob", false},
+	}
+	for _, test := range tests {
+		game := NewGame(LocalMultiplayer, "alice", "bob", DefaultRules())
+		game.Start()
+		playColumns(t, game, test.before...)
+		if err := game.OfferDraw(test.offerBy); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		playColumns(t, game, test.after...)
+		if test.decline {
+			if err := game.DeclineDraw("bob"); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+
+		err := game.AcceptDraw(test.accepts)
+		if accepted := err == nil; accepted != test.want {
+			t.Errorf("%s: accepted %v (%v), want %v", test.name, accepted, err, test.want)
+		}
+		if finished := game.Status == StatusFinished && game.Outcome == OutcomeDraw && game.WinnerID == ""; finished != test.want {
+			t.Errorf("%s: game %s %q won by %q", test.name, game.Status, game.Outcome, game.WinnerID)
+		}
+	}
+}
+
+func TestResign(t *testing.T) {
+	tests := []struct {
+		name    string
+		player2 string
+		moves   []int
+		resigns string
+		winner  string // Empty if the resignation is refused
+	}{
+		{"on the player's turn", "bob", nil, "alice", "bob"},
+		{"on the opponent's turn", "bob", nil, "bob", "alice"},
+		{"against the bot", "bot", []int{3, 3}, "alice", "bot"},
+		{"for the bot", "bot", []int{3, 3}, "bot", ""},
+		{"someone else", "bob", nil, "carol", ""},
+		{"after the game ended", "bob", []int{0, 6, 1, 6, 2, 6, 3}, "bob", ""},
+	}
+	for _, test := range tests {
+		game := NewGame(LocalMultiplayer, "alice", test.player2, DefaultRules())
+		game.Start()
+		playColumns(t, game, test.moves...)
+		status, winner := game.Status, game.WinnerID
+
+		err := game.Resign(test.resigns)
+		if test.winner == "" {
+			if err == nil || game.Status != status || game.WinnerID != winner {
+				t.Errorf("%s: the resignation was accepted", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if game.Status != StatusFinished || game.Outcome != OutcomeResignation || game.WinnerID != test.winner {
+			t.Errorf("%s: game %s %q won by %q, want a resignation won by %s", test.name, game.Status, game.Outcome, game.WinnerID, test.winner)
+		}
+	}
+}
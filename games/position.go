@@ -0,0 +1,358 @@
+package games
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Position is a compact bitboard representation of a board.
+//
+// Each column takes height+1 bits of a uint64, bottom cell first. The extra
+// bit on top of every column is always empty so that lines can't wrap from
+// one column into the next when the masks are shifted.
+type Position struct {
+	red     uint64
+	yellow  uint64
+	heights [MaxBoardWidth]int
+	hash    uint64 // Zobrist hash of the discs, kept up to date by every change
+
+	width   int
+	height  int
+	connect int
+	variant Variant
+}
+
+// NewPosition returns an empty position for the rules.
+// The rules must be valid, see Rules.Validate.
+func NewPosition(rules Rules) Position {
+	return Position{
+		width:   rules.Width,
+		height:  rules.Height,
+		connect: rules.Connect,
+		variant: rules.Variant,
+	}
+}
+
+// PositionFromGrid converts a [][]int board (row 0 is the top row) into a position
+func PositionFromGrid(grid [][]int, rules Rules) (Position, error) {
+	if err := rules.Validate(); err != nil {
+		return Position{}, err
+	}
+	pos := NewPosition(rules)
+
+	if len(grid) != pos.height {
+		return pos, errors.New("board has wrong number of rows")
+	}
+	for _, row := range grid {
+		if len(row) != pos.width {
+			return pos, errors.New("board has wrong number of columns")
+		}
+	}
+
+	// Fill every column from the bottom up, discs can't float above an empty cell
+	for col := 0; col < pos.width; col++ {
+		for row := pos.height - 1; row >= 0; row-- {
+			cell := grid[row][col]
+			if cell == EmptyCell {
+				continue
+			}
+			if cell != RedToken && cell != YellowToken {
+				return pos, errors.New("board contains an invalid token")
+			}
+			if pos.height-1-row != pos.heights[col] {
+				return pos, errors.New("board contains a floating disc")
+			}
+			pos.Play(col, cell)
+		}
+	}
+
+	return pos, nil
+}
+
+// Grid converts the position back into a [][]int board (row 0 is the top row)
+func (p Position) Grid() [][]int {
+	grid := make([][]int, p.height)
+	for row := range grid {
+		grid[row] = make([]int, p.width)
+		for col := 0; col < p.width; col++ {
+			grid[row][col] = p.Cell(row, col)
+		}
+	}
+	return grid
+}
+
+// MarshalJSON keeps the board JSON shape as a [][]int grid
+func (p Position) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Grid())
+}
+
+// UnmarshalJSON reads a position from a [][]int grid.
+// The grid doesn't say how many discs in a line win or which variant is
+// played, so those are kept from the receiver or take their defaults. A
+// game's board is read with the game's rules, see Game.UnmarshalJSON.
+func (p *Position) UnmarshalJSON(data []byte) error {
+	var grid [][]int
+	if err := json.Unmarshal(data, &grid); err != nil {
+		return err
+	}
+	rules := Rules{Connect: p.connect, Variant: p.variant}
+	if rules.Connect == 0 {
+		rules.Connect = ConnectLength
+	}
+	if rules.Variant == "" {
+		rules.Variant = VariantStandard
+	}
+	rules.Height = len(grid)
+	if rules.Height > 0 {
+		rules.Width = len(grid[0])
+	}
+	pos, err := PositionFromGrid(grid, rules)
+	if err != nil {
+		return err
+	}
+	*p = pos
+	return nil
+}
+
+// Cell returns the token at the given row (0 is the top row) and column
+func (p Position) Cell(row, col int) int {
+	if row < 0 || row >= p.height || col < 0 || col >= p.width {
+		return EmptyCell
+	}
+	bit := p.cellBit(p.height-1-row, col)
+	if p.red&bit != 0 {
+		return RedToken
+	}
+	if p.yellow&bit != 0 {
+		return YellowToken
+	}
+	return EmptyCell
+}
+
+// CanPlay reports whether a disc can be dropped in the column
+func (p Position) CanPlay(col int) bool {
+	return col >= 0 && col < p.width && p.heights[col] < p.height
+}
+
+// Play drops a disc for the token in the column and returns the row it landed on.
+// The caller must check CanPlay first.
+func (p *Position) Play(col, token int) int {
+	bit := p.cellBit(p.heights[col], col)
+	if token == RedToken {
+		p.red |= bit
+	} else {
+		p.yellow |= bit
+	}
+	p.hash ^= zobristKeys[p.cellIndex(p.heights[col], col)][token-1]
+	p.heights[col]++
+	return p.height - p.heights[col]
+}
+
+// CanPop reports whether the token owns the bottom disc of the column in a PopOut game
+func (p Position) CanPop(col, token int) bool {
+	if p.variant != VariantPopOut || col < 0 || col >= p.width || p.heights[col] == 0 {
+		return false
+	}
+	return p.Cell(p.height-1, col) == token
+}
+
+// Pop removes the bottom disc of the column and shifts the rest of the column down.
+// The caller must check CanPop first.
+func (p *Position) Pop(col int) {
+	column := p.columnMask(col)
+	bottom := p.cellBit(0, col)
+	p.hash ^= p.columnHash(col)
+	p.red = p.red&^column | (p.red&column&^bottom)>>1
+	p.yellow = p.yellow&^column | (p.yellow&column&^bottom)>>1
+	p.heights[col]--
+	p.hash ^= p.columnHash(col)
+}
+
+// Unpop puts a popped disc of the token back under the column, undoing Pop
+func (p *Position) Unpop(col, token int) {
+	column := p.columnMask(col)
+	p.hash ^= p.columnHash(col)
+	p.red = p.red&^column | (p.red&column)<<1&column
+	p.yellow = p.yellow&^column | (p.yellow&column)<<1&column
+	if token == RedToken {
+		p.red |= p.cellBit(0, col)
+	} else {
+		p.yellow |= p.cellBit(0, col)
+	}
+	p.heights[col]++
+	p.hash ^= p.columnHash(col)
+}
+
+// HasLegalMove reports whether the token can drop or pop a disc anywhere
+func (p Position) HasLegalMove(token int) bool {
+	for col := 0; col < p.width; col++ {
+		if p.CanPlay(col) || p.CanPop(col, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Undo removes the top disc of the column.
+// The caller must make sure the column isn't empty.
+func (p *Position) Undo(col int) {
+	p.heights[col]--
+	bit := p.cellBit(p.heights[col], col)
+	if p.red&bit != 0 {
+		p.hash ^= zobristKeys[p.cellIndex(p.heights[col], col)][RedToken-1]
+	} else {
+		p.hash ^= zobristKeys[p.cellIndex(p.heights[col], col)][YellowToken-1]
+	}
+	p.red &^= bit
+	p.yellow &^= bit
+}
+
+// ColumnHeight returns the number of discs in the column
+func (p Position) ColumnHeight(col int) int {
+	return p.heights[col]
+}
+
+// HasWon reports whether the token has a winning line anywhere on the board
+func (p Position) HasWon(token int) bool {
+	if token == RedToken {
+		return p.connected(p.red)
+	}
+	return p.connected(p.yellow)
+}
+
+// WinningCells returns every cell that is part of a winning line of the token,
+// including lines longer than the connect length and several lines at once
+func (p Position) WinningCells(token int) []Cell {
+	b := p.yellow
+	if token == RedToken {
+		b = p.red
+	}
+
+	var lines uint64
+	h := uint(p.height)
+	for _, shift := range []uint{1, h, h + 1, h + 2} {
+		// m keeps the first cell of every line, spread it over the whole line
+		m := b
+		for i := 1; i < p.connect && m != 0; i++ {
+			m &= b >> (uint(i) * shift)
+		}
+		for i := 0; i < p.connect && m != 0; i++ {
+			lines |= m << (uint(i) * shift)
+		}
+	}
+
+	cells := []Cell{}
+	for row := 0; row < p.height; row++ {
+		for col := 0; col < p.width; col++ {
+			if lines&p.cellBit(p.height-1-row, col) != 0 {
+				cells = append(cells, Cell{Row: row, Column: col})
+			}
+		}
+	}
+	return cells
+}
+
+// IsFull reports whether every column is full
+func (p Position) IsFull() bool {
+	return p.MoveCount() == p.width*p.height
+}
+
+// MoveCount returns the number of discs on the board
+func (p Position) MoveCount() int {
+	count := 0
+	for col := 0; col < p.width; col++ {
+		count += p.heights[col]
+	}
+	return count
+}
+
+// Key returns a value that uniquely identifies the position
+func (p Position) Key() uint64 {
+	// red + all discs + bottom row sets one extra bit on top of each column,
+	// which encodes the column heights together with the red discs
+	return p.red + (p.red | p.yellow) + p.bottomMask()
+}
+
+// Hash returns the Zobrist hash of the discs on the board. Unlike Key it
+// can collide, but it is updated with every move instead of recomputed.
+func (p Position) Hash() uint64 {
+	return p.hash
+}
+
+// Width returns the number of columns
+func (p Position) Width() int {
+	return p.width
+}
+
+// Height returns the number of rows
+func (p Position) Height() int {
+	return p.height
+}
+
+// Connect returns the number of discs in a line needed to win
+func (p Position) Connect() int {
+	return p.connect
+}
+
+// Variant returns the rules variant the position is played under
+func (p Position) Variant() Variant {
+	return p.variant
+}
+
+// Rules returns the rules the position is played under
+func (p Position) Rules() Rules {
+	return Rules{Width: p.width, Height: p.height, Connect: p.connect, Variant: p.variant}
+}
+
+// cellBit returns the bit for a cell, height 0 is the bottom of the column
+func (p Position) cellBit(height, col int) uint64 {
+	return 1 << uint(p.cellIndex(height, col))
+}
+
+// cellIndex returns the bit number of a cell, height 0 is the bottom of the column
+func (p Position) cellIndex(height, col int) int {
+	return col*(p.height+1) + height
+}
+
+// columnHash returns the part of the hash made up by the discs of a column
+func (p Position) columnHash(col int) uint64 {
+	var hash uint64
+	for h := 0; h < p.heights[col]; h++ {
+		if p.red&p.cellBit(h, col) != 0 {
+			hash ^= zobristKeys[p.cellIndex(h, col)][RedToken-1]
+		} else {
+			hash ^= zobristKeys[p.cellIndex(h, col)][YellowToken-1]
+		}
+	}
+	return hash
+}
+
+// columnMask returns a mask with every playable cell of the column set
+func (p Position) columnMask(col int) uint64 {
+	return (uint64(1)<<uint(p.height) - 1) << uint(col*(p.height+1))
+}
+
+// bottomMask returns a mask with the bottom cell of every column set
+func (p Position) bottomMask() uint64 {
+	var mask uint64
+	for col := 0; col < p.width; col++ {
+		mask |= p.cellBit(0, col)
+	}
+	return mask
+}
+
+// connected checks a single player's mask for a winning line
+func (p Position) connected(b uint64) bool {
+	// vertical, diagonal (\), horizontal, diagonal (/)
+	h := uint(p.height)
+	for _, shift := range []uint{1, h, h + 1, h + 2} {
+		m := b
+		for i := 1; i < p.connect && m != 0; i++ {
+			m &= b >> (uint(i) * shift)
+		}
+		if m != 0 {
+			return true
+		}
+	}
+	return false
+}
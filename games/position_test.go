@@ -0,0 +1,219 @@
+package games
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// gridPosition builds a position from rows written top to bottom, R and Y
+// for the discs and . for an empty cell
+func gridPosition(t *testing.T, rules Rules, rows ...string) Position {
+	t.Helper()
+	pos, err := PositionFromGrid(tokenGrid(rows...), rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pos
+}
+
+// tokenGrid converts rows written top to bottom into a [][]int board
+func tokenGrid(rows ...string) [][]int {
+	grid := make([][]int, len(rows))
+	for r, row := range rows {
+		grid[r] = make([]int, len(row))
+		for c := 0; c < len(row); c++ {
+			switch row[c] {
+			case 'R':
+				grid[r][c] = RedToken
+			case 'Y':
+				grid[r][c] = YellowToken
+			}
+		}
+	}
+	return grid
+}
+
+func TestBoardRoundTrip(t *testing.T) {
+	wide := Rules{Width: 8, Height: 7, Connect: 5, Variant: VariantStandard}
+	tests := []struct {
+		rows  []string
+		rules Rules
+	}{
+		{[]string{".......", ".......", ".......", ".......", ".......", "......."}, DefaultRules()},
+		{[]string{".......", ".......", ".......", ".......", "...Y...", "..RR..."}, DefaultRules()},
+		{[]string{".YRRRY.", "RRYYYR.", "YYYRYYR", "RRRYYYR", "RYRRRYR", "RYYRYRY"}, DefaultRules()},
+		{[]string{"........", "........", "........", "....Y...", "....R...", "...YR...", "..RYRY.."}, wide},
+	}
+	for _, test := range tests {
+		rows := test.rows
+		pos := gridPosition(t, test.rules, rows...)
+		if got := pos.Grid(); !reflect.DeepEqual(got, tokenGrid(rows...)) {
+			t.Errorf("%v came back as %v", rows, got)
+		}
+
+		data, err := json.Marshal(pos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fromJSON := NewPosition(test.rules)
+		if err := json.Unmarshal(data, &fromJSON); err != nil {
+			t.Fatal(err)
+		}
+		if fromJSON != pos {
+			t.Errorf("%v: the JSON gives a different position", rows)
+		}
+	}
+}
+
+func TestPositionFromGridErrors(t *testing.T) {
+	tests := map[string][][]int{
+		"floating disc": tokenGrid(".......", ".......", ".......", "...R...", ".......", "......."),
+		"wrong width":   tokenGrid("......", "......", "......", "......", "......", "......"),
+		"wrong height":  tokenGrid(".......", ".......", ".......", ".......", "......."),
+		"invalid token": {{0, 0, 0, 0, 0, 0, 0}, {0, 0, 0, 0, 0, 0, 0}, {0, 0, 0, 0, 0, 0, 0}, {0, 0, 0, 0, 0, 0, 0}, {0, 0, 0, 0, 0, 0, 0}, {0, 0, 0, 3, 0, 0, 0}},
+	}
+	for name, grid := range tests {
+		if _, err := PositionFromGrid(grid, DefaultRules()); err == nil {
+			t.Errorf("%s: the grid was accepted", name)
+		}
+	}
+}
+
+func TestHasWon(t *testing.T) {
+	tests := []struct {
+		name   string
+		rows   []string
+		winner int // EmptyCell for nobody
+	}{
+		{"horizontal", []string{".......", ".......", ".......", ".......", "YYY....", "RRRR..."}, RedToken},
+		{"vertical", []string{".......", ".......", "......Y", "......Y", "R.....Y", "R.R...Y"}, YellowToken},
+		{"diagonal up", []string{".......", ".......", "...R...", "..RY...", ".RYY...", "RYYR..."}, RedToken},
+		{"diagonal down", []string{".......", ".......", "Y......", "RY.....", "RRY....", "RRYY..."}, YellowToken},
+		{"three in a row", []string{".......", ".......", ".......", ".......", "YYY....", "RRR...."}, EmptyCell},
+		// The bottom of a column follows the top of the one before it in the bitboard
+		{"no wrap between columns", []string{"R......", "R......", "Y......", "Y......", "RR.....", "YR....."}, EmptyCell},
+	}
+	for _, test := range tests {
+		pos := gridPosition(t, DefaultRules(), test.rows...)
+		for _, token := range []int{RedToken, YellowToken} {
+			if got, want := pos.HasWon(token), token == test.winner; got != want {
+				t.Errorf("%s: HasWon(%d) = %v, want %v", test.name, token, got, want)
+			}
+		}
+	}
+}
+
+func TestHasWonConnectLength(t *testing.T) {
+	rules := Rules{Width: 8, Height: 7, Connect: 5, Variant: VariantStandard}
+	rows := func(bottom string) []string {
+		return append(strings.Fields(strings.Repeat("........ ", 6)), bottom)
+	}
+	if gridPosition(t, rules, rows("RRRR....")...).HasWon(RedToken) {
+		t.Error("four in a row won a connect five game")
+	}
+	if !gridPosition(t, rules, rows("RRRRR...")...).HasWon(RedToken) {
+		t.Error("five in a row didn't win a connect five game")
+	}
+}
+
+func TestPopUnpop(t *testing.T) {
+	rules := DefaultRules()
+	rules.Variant = VariantPopOut
+	pos := gridPosition(t, rules, ".......", ".......", ".......", "..Y....", "..RY...", ".YRRY..")
+
+	if pos.CanPop(1, RedToken) || !pos.CanPop(1, YellowToken) {
+		t.Error("only yellow may pop its disc at the bottom of column 1")
+	}
+	if pos.CanPop(0, RedToken) || pos.CanPop(0, YellowToken) {
+		t.Error("an empty column was poppable")
+	}
+
+	popped := pos
+	popped.Pop(2)
+	want := tokenGrid(".......", ".......", ".......", ".......", "..YY...", ".YRRY..")
+	if got := popped.Grid(); !reflect.DeepEqual(got, want) {
+		t.Errorf("popping column 2 gave %v", got)
+	}
+	popped.Unpop(2, RedToken)
+	if popped != pos {
+		t.Errorf("unpop gave %v", popped.Grid())
+	}
+
+	standard := gridPosition(t, DefaultRules(), ".......", ".......", ".......", ".......", ".......", "...R...")
+	if standard.CanPop(3, RedToken) {
+		t.Error("popping was allowed in a standard game")
+	}
+}
+
+// TestHashConsistency plays random drops and pops and checks that the hash
+// kept up to date by every change is the hash of the discs on the board
+func TestHashConsistency(t *testing.T) {
+	rules := DefaultRules()
+	rules.Variant = VariantPopOut
+	rng := rand.New(rand.NewSource(1))
+	for game := 0; game < 50; game++ {
+		pos, token := NewPosition(rules), RedToken
+		for ply := 0; ply < 60 && !pos.HasWon(RedToken) && !pos.HasWon(YellowToken); ply++ {
+			// Drops are columns, pops are columns after the width
+			actions := []int{}
+			for col := 0; col < pos.Width(); col++ {
+				if pos.CanPlay(col) {
+					actions = append(actions, col)
+				}
+				if pos.CanPop(col, token) {
+					actions = append(actions, pos.Width()+col)
+				}
+			}
+			if len(actions) == 0 {
+				break
+			}
+			action := actions[rng.Intn(len(actions))]
+			before := pos
+			if action < pos.Width() {
+				pos.Play(action, token)
+			} else {
+				pos.Pop(action - pos.Width())
+			}
+
+			rebuilt, err := PositionFromGrid(pos.Grid(), rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pos.Hash() != rebuilt.Hash() || pos.Key() != rebuilt.Key() {
+				t.Fatalf("%s: hash %x, the same discs hash to %x", FormatBoard(pos, token), pos.Hash(), rebuilt.Hash())
+			}
+
+			// Taking the move back gives the position before it, hash included
+			undone := pos
+			if action < pos.Width() {
+				undone.Undo(action)
+			} else {
+				undone.Unpop(action-pos.Width(), token)
+			}
+			if undone != before {
+				t.Fatalf("%s: taking back action %d didn't restore the position", FormatBoard(before, token), action)
+			}
+			token = otherToken(token)
+		}
+	}
+}
+
+func TestHashTranspositions(t *testing.T) {
+	play := func(columns ...int) Position {
+		pos, token := NewPosition(DefaultRules()), RedToken
+		for _, col := range columns {
+			pos.Play(col, token)
+			token = otherToken(token)
+		}
+		return pos
+	}
+	if play(3, 2, 4, 5).Hash() != play(4, 5, 3, 2).Hash() {
+		t.Error("the same position reached in another order has another hash")
+	}
+	if play(3, 2, 4, 5).Hash() == play(2, 3, 4, 5).Hash() {
+		t.Error("swapping the colors of two discs kept the hash")
+	}
+}
@@ -0,0 +1,225 @@
+package games
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// MaxProfileWeight bounds every weight of a profile. Even the largest board
+// has few enough windows that an evaluation stays below a win's score.
+const MaxProfileWeight = 2000
+
+// DefaultProfile is the profile bots evaluate with unless their game picks another
+const DefaultProfile = "default"
+
+// EvalProfile holds the weights the bot judges a position with, see evaluateWindow.
+// A window is connect-length cells in a line.
+type EvalProfile struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	ThreeInRow  int    `json:"threeInRow"` // Window one disc short of the bot's line, the rest empty
+	TwoInRow    int    `json:"twoInRow"`   // Window two discs short of the bot's line
+	OneInRow    int    `json:"oneInRow"`   // Window with a single disc of the bot
+	BlockThree  int    `json:"blockThree"` // Penalty for a window one disc short of the opponent's line
+	BlockTwo    int    `json:"blockTwo"`   // Penalty for a window two discs short of the opponent's line
+	Center      int    `json:"center"`     // Bonus for every disc of the bot in the center column
+}
+
+// defaultProfile has the weights the bot was first written with
+var defaultProfile = EvalProfile{
+	Name:        DefaultProfile,
+	Description: "Balanced, blocking a three counts double",
+	ThreeInRow:  ThreeInRow,
+	TwoInRow:    TwoInRow,
+	OneInRow:    OneInRow,
+	BlockThree:  ThreeInRow * 2,
+	BlockTwo:    TwoInRow,
+	Center:      3,
+}
+
+// profileNamePattern keeps profile names usable in URLs and file names
+var profileNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// profile registry, name -> profile
+var (
+	profiles     = map[string]EvalProfile{DefaultProfile: defaultProfile}
+	profileMutex = &sync.RWMutex{}
+)
+
+// Validate checks the profile's name and weights
+func (p EvalProfile) Validate() error {
+	if !profileNamePattern.MatchString(p.Name) {
+		return errors.New("profile name must be lower case letters, digits, - or _")
+	}
+	weights := []struct {
+		name   string
+		weight int
+	}{
+		{"threeInRow", p.ThreeInRow},
+		{"twoInRow", p.TwoInRow},
+		{"oneInRow", p.OneInRow},
+		{"blockThree", p.BlockThree},
+		{"blockTwo", p.BlockTwo},
+		{"center", p.Center},
+	}
+	for _, w := range weights {
+		if w.weight < 0 || w.weight > MaxProfileWeight {
+			return fmt.Errorf("%s must be between 0 and %d", w.name, MaxProfileWeight)
+		}
+	}
+	return nil
+}
+
+// LoadProfile reads a profile file
+func LoadProfile(path string) (EvalProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return EvalProfile{}, err
+	}
+	var profile EvalProfile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields() // A misspelled weight would silently be 0
+	if err := decoder.Decode(&profile); err != nil {
+		return EvalProfile{}, err
+	}
+	if err := profile.Validate(); err != nil {
+		return EvalProfile{}, err
+	}
+	return profile, nil
+}
+
+// Save writes the profile to a file
+func (p EvalProfile) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// LoadProfiles reads every .json file of a directory as a profile and
+// registers them. Nothing is registered if any profile is invalid.
+func LoadProfiles(dir string) ([]EvalProfile, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	loaded := []EvalProfile{}
+	seen := map[string]string{}
+	for _, path := range paths {
+		profile, err := LoadProfile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if other, ok := seen[profile.Name]; ok {
+			return nil, fmt.Errorf("%s: profile %s is already defined in %s", path, profile.Name, other)
+		}
+		seen[profile.Name] = path
+		loaded = append(loaded, profile)
+	}
+
+	for _, profile := range loaded {
+		RegisterProfile(profile)
+	}
+	return loaded, nil
+}
+
+// RegisterProfile makes a profile available by its name, the profile must
+// be valid. A profile named "default" replaces the built in weights.
+func RegisterProfile(profile EvalProfile) {
+	profileMutex.Lock()
+	defer profileMutex.Unlock()
+	profiles[profile.Name] = profile
+}
+
+// GetProfile returns the registered profile with the name
+func GetProfile(name string) (EvalProfile, error) {
+	profileMutex.RLock()
+	defer profileMutex.RUnlock()
+	profile, ok := profiles[name]
+	if !ok {
+		return EvalProfile{}, errors.New("unknown evaluation profile " + name)
+	}
+	return profile, nil
+}
+
+// Profiles lists the registered profiles by name
+func Profiles() []EvalProfile {
+	profileMutex.RLock()
+	defer profileMutex.RUnlock()
+	list := make([]EvalProfile, 0, len(profiles))
+	for _, profile := range profiles {
+		list = append(list, profile)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// ProfiledStrategy is a strategy whose evaluation weights can be changed
+type ProfiledStrategy interface {
+	Strategy
+	SetProfile(profile EvalProfile)
+}
+
+// SetProfile changes the weights the bot evaluates with
+func (bot *BotPlayer) SetProfile(profile EvalProfile) {
+	if bot.Profile == profile {
+		return
+	}
+	bot.Profile = profile
+
+	// Scores stored with the old weights would mislead the search
+	if bot.TransTable != nil {
+		bot.TransTable.Clear()
+	}
+}
+
+// SetProfile changes the weights of the minimax bot the solver falls back to
+func (s *SolverStrategy) SetProfile(profile EvalProfile) {
+	s.Bot.SetProfile(profile)
+}
+
+// SetBotProfile makes the game's bots evaluate with the named profile, it
+// is kept when the game is reset. Strategies that don't evaluate positions
+// are left alone.
+func (g *Game) SetBotProfile(name string) error {
+	profile, err := GetProfile(name)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, bot := range g.bots {
+		if profiled, ok := bot.(ProfiledStrategy); ok {
+			profiled.SetProfile(profile)
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("no bot of the game uses evaluation profiles")
+	}
+	g.BotProfile = name
+	return nil
+}
+
+// SetSeatBotProfile changes the profile of the bot playing the token only
+func (g *Game) SetSeatBotProfile(token int, name string) error {
+	profiled, ok := g.BotFor(token).(ProfiledStrategy)
+	if !ok {
+		return errors.New("no bot with evaluation profiles plays this color")
+	}
+	profile, err := GetProfile(name)
+	if err != nil {
+		return err
+	}
+	profiled.SetProfile(profile)
+	return nil
+}
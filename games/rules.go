@@ -0,0 +1,68 @@
+package games
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Variant selects a rules variant
+type Variant string
+
+// Rules describes the board size, how many discs in a line win a game and the rules variant
+type Rules struct {
+	Width   int     `json:"width"`
+	Height  int     `json:"height"`
+	Connect int     `json:"connect"`
+	Variant Variant `json:"variant"`
+}
+
+// DefaultRules returns the classic 7x6 connect four rules
+func DefaultRules() Rules {
+	return Rules{
+		Width:   BoardWidth,
+		Height:  BoardHeight,
+		Connect: ConnectLength,
+		Variant: VariantStandard,
+	}
+}
+
+// WithDefaults fills every unset field from DefaultRules
+func (r Rules) WithDefaults() Rules {
+	defaults := DefaultRules()
+	if r.Width == 0 {
+		r.Width = defaults.Width
+	}
+	if r.Height == 0 {
+		r.Height = defaults.Height
+	}
+	if r.Connect == 0 {
+		r.Connect = defaults.Connect
+	}
+	if r.Variant == "" {
+		r.Variant = defaults.Variant
+	}
+	return r
+}
+
+// Validate checks that the rules describe a playable board that fits in a bitboard
+func (r Rules) Validate() error {
+	if r.Width < 1 || r.Height < 1 {
+		return errors.New("board width and height must be positive")
+	}
+	if r.Width > MaxBoardWidth {
+		return fmt.Errorf("board width can be at most %d", MaxBoardWidth)
+	}
+	if r.Width*(r.Height+1) > MaxBoardCells {
+		return fmt.Errorf("board is too large, width * (height + 1) can be at most %d", MaxBoardCells)
+	}
+	if r.Connect < 2 {
+		return errors.New("connect length must be at least 2")
+	}
+	if r.Connect > r.Width && r.Connect > r.Height {
+		return errors.New("connect length doesn't fit on the board")
+	}
+	if r.Variant != VariantStandard && r.Variant != VariantPopOut {
+		return fmt.Errorf("unknown variant %q", r.Variant)
+	}
+	return nil
+}
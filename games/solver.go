@@ -0,0 +1,354 @@
+package games
+
+import (
+	"errors"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// Solver values for the player to move
+const (
+	SolveWin  = "win"
+	SolveLoss = "loss"
+	SolveDraw = "draw"
+)
+
+// solverTableBits sizes the solver's table at 2^22 entries of 9 bytes, about 38 MB
+const solverTableBits = 22
+
+var (
+	ErrSolveUnsupported = errors.New("the solver only plays standard four in a row")
+	ErrSolveTimeout     = errors.New("the solver ran out of time")
+)
+
+// SolveResult is the value of a position with perfect play from both sides
+type SolveResult struct {
+	Value string `json:"value"` // SolveWin, SolveLoss or SolveDraw for the player to move
+	Score int    `json:"score"` // Positive for a win, the sooner the higher, negative for a loss
+	Plies int    `json:"plies"` // Moves left until the game ends, counting both players
+}
+
+// Solver finds the exact value of standard four in a row positions with a
+// negamax search on a null window, in the manner of Pascal Pons' solver.
+// Its table is kept between calls, a Solver must not be used concurrently.
+type Solver struct {
+	keys   []uint64
+	values []int8 // 0 empty, positive an upper bound, negative a lower bound
+
+	width, height int
+	boardMask     uint64
+	bottom        uint64
+	minScore      int
+	maxScore      int
+
+	deadline time.Time
+	timedOut bool
+	Nodes    int64 // Positions searched by the last call
+}
+
+// solverPool keeps warm solvers for Solve and SolveColumns
+var solverPool = sync.Pool{New: func() any { return NewSolver() }}
+
+// NewSolver creates a solver with an empty table
+func NewSolver() *Solver {
+	return &Solver{
+		keys:   make([]uint64, 1<<solverTableBits),
+		values: make([]int8, 1<<solverTableBits),
+	}
+}
+
+// Solve returns the value of the position for the token to move. It is
+// practical for a 7x6 board from SolverMinPly on, deadline bounds the
+// time it takes, a zero deadline means no limit.
+func Solve(pos Position, token int, deadline time.Time) (SolveResult, error) {
+	s := solverPool.Get().(*Solver)
+	defer solverPool.Put(s)
+	return s.Solve(pos, token, deadline)
+}
+
+// SolveColumns returns the value for the token of playing each column, nil
+// for columns that are full. See Solve.
+func SolveColumns(pos Position, token int, deadline time.Time) ([]*SolveResult, error) {
+	s := solverPool.Get().(*Solver)
+	defer solverPool.Put(s)
+	return s.SolveColumns(pos, token, deadline)
+}
+
+// Solve returns the value of the position for the token to move
+func (s *Solver) Solve(pos Position, token int, deadline time.Time) (SolveResult, error) {
+	current, mask, err := s.setup(pos, token, deadline)
+	if err != nil {
+		return SolveResult{}, err
+	}
+	moves := bits.OnesCount64(mask)
+
+	score := s.solve(current, mask, moves)
+	if s.timedOut {
+		return SolveResult{}, ErrSolveTimeout
+	}
+	return s.result(score, moves), nil
+}
+
+// SolveColumns returns the value for the token of playing each column
+func (s *Solver) SolveColumns(pos Position, token int, deadline time.Time) ([]*SolveResult, error) {
+	current, mask, err := s.setup(pos, token, deadline)
+	if err != nil {
+		return nil, err
+	}
+	moves := bits.OnesCount64(mask)
+
+	results := make([]*SolveResult, s.width)
+	for col := 0; col < s.width; col++ {
+		if mask&s.topCell(col) != 0 {
+			continue
+		}
+		move := (mask + s.bottomCell(col)) & s.columnMask(col)
+
+		// A winning drop ends the game, otherwise the opponent moves next
+		var score int
+		if s.winningCells(current, mask)&move != 0 {
+			score = (s.width*s.height + 1 - moves) / 2
+		} else {
+			score = -s.solve(current^mask, mask|move, moves+1)
+		}
+		if s.timedOut {
+			return nil, ErrSolveTimeout
+		}
+		result := s.result(score, moves)
+		results[col] = &result
+	}
+	return results, nil
+}
+
+// setup checks the position and returns the discs of the player to move and all discs
+func (s *Solver) setup(pos Position, token int, deadline time.Time) (uint64, uint64, error) {
+	if pos.variant != VariantStandard || pos.connect != 4 {
+		return 0, 0, ErrSolveUnsupported
+	}
+	if pos.HasWon(RedToken) || pos.HasWon(YellowToken) {
+		return 0, 0, errors.New("position is already won")
+	}
+
+	// A table filled for another board size would give wrong answers
+	if pos.width != s.width || pos.height != s.height {
+		clear(s.keys)
+		clear(s.values)
+		s.width, s.height = pos.width, pos.height
+		s.bottom = pos.bottomMask()
+		s.boardMask = s.bottom * (1<<uint(s.height) - 1)
+		s.minScore = -(s.width*s.height)/2 + 3
+		s.maxScore = (s.width*s.height+1)/2 - 3
+	}
+	s.deadline = deadline
+	s.timedOut = false
+	s.Nodes = 0
+
+	current := pos.red
+	if token == YellowToken {
+		current = pos.yellow
+	}
+	return current, pos.red | pos.yellow, nil
+}
+
+// result converts a score into a value and distance to the end
+func (s *Solver) result(score int, moves int) SolveResult {
+	cells := s.width * s.height
+	if score == 0 {
+		return SolveResult{Value: SolveDraw, Score: 0, Plies: cells - moves}
+	}
+
+	// The winner's last disc is dropped when last discs are already on the
+	// board, last has the parity of the winner's turns
+	value, winScore, parity := SolveWin, score, moves
+	if score < 0 {
+		value, winScore, parity = SolveLoss, -score, moves+1
+	}
+	last := cells + 1 - 2*winScore
+	if last%2 != parity%2 {
+		last--
+	}
+	return SolveResult{Value: value, Score: score, Plies: last - moves + 1}
+}
+
+// solve narrows the score down with null window searches
+func (s *Solver) solve(current, mask uint64, moves int) int {
+	cells := s.width * s.height
+	if s.winningCells(current, mask)&s.possible(mask) != 0 {
+		return (cells + 1 - moves) / 2
+	}
+
+	min := -(cells - moves) / 2
+	max := (cells + 1 - moves) / 2
+	for min < max && !s.timedOut {
+		// Search close to zero first, most positions are near a draw
+		med := min + (max-min)/2
+		if med <= 0 && min/2 < med {
+			med = min / 2
+		} else if med >= 0 && max/2 > med {
+			med = max / 2
+		}
+		r := s.negamax(current, mask, moves, med, med+1)
+		if r <= med {
+			max = r
+		} else {
+			min = r
+		}
+	}
+	return min
+}
+
+// negamax returns the score of a position in which the player to move can't
+// win immediately. Scores outside alpha and beta are only bounds.
+func (s *Solver) negamax(current, mask uint64, moves int, alpha, beta int) int {
+	s.Nodes++
+	if s.Nodes&0xFFFF == 0 && !s.deadline.IsZero() && time.Now().After(s.deadline) {
+		s.timedOut = true
+	}
+	if s.timedOut {
+		return 0
+	}
+
+	cells := s.width * s.height
+	next := s.nonLosingMoves(current, mask)
+	if next == 0 {
+		return -(cells - moves) / 2 // Every move lets the opponent win
+	}
+	if moves >= cells-2 {
+		return 0 // Neither player can win with the last two discs
+	}
+
+	// The opponent can't win on their next move, so the score has a floor
+	min := -(cells - 2 - moves) / 2
+	if alpha < min {
+		alpha = min
+		if alpha >= beta {
+			return alpha
+		}
+	}
+
+	// And the player to move can't win on this move
+	max := (cells - 1 - moves) / 2
+
+	key := current + mask + s.bottom
+	slot := (key * 0x9E3779B97F4A7C15) >> (64 - solverTableBits)
+	if s.keys[slot] == key && s.values[slot] != 0 {
+		if v := int(s.values[slot]); v > 0 {
+			max = v + s.minScore - 1
+		} else {
+			min = -v + s.minScore - 1
+			if alpha < min {
+				alpha = min
+				if alpha >= beta {
+					return alpha
+				}
+			}
+		}
+	}
+	if beta > max {
+		beta = max
+		if alpha >= beta {
+			return beta
+		}
+	}
+
+	// Try the moves that open the most new threats first, center columns break ties
+	var sorted [MaxBoardWidth]uint64
+	var scores [MaxBoardWidth]int
+	count := 0
+	for i := 0; i < s.width; i++ {
+		col := s.width/2 + (1-2*(i%2))*(i+1)/2
+		if s.width%2 == 0 {
+			col = s.width/2 - 1 - (1-2*(i%2))*(i+1)/2
+		}
+		move := next & s.columnMask(col)
+		if move == 0 {
+			continue
+		}
+		score := bits.OnesCount64(s.winningCells(current|move, mask))
+		j := count
+		for ; j > 0 && scores[j-1] < score; j-- {
+			sorted[j], scores[j] = sorted[j-1], scores[j-1]
+		}
+		sorted[j], scores[j] = move, score
+		count++
+	}
+
+	for i := 0; i < count; i++ {
+		move := sorted[i]
+		score := -s.negamax(current^mask, mask|move, moves+1, -beta, -alpha)
+		if s.timedOut {
+			return 0
+		}
+		if score >= beta {
+			s.keys[slot] = key
+			s.values[slot] = int8(-(score - s.minScore + 1))
+			return score
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+
+	s.keys[slot] = key
+	s.values[slot] = int8(alpha - s.minScore + 1)
+	return alpha
+}
+
+// nonLosingMoves returns the drops that don't hand the opponent a win on their next move
+func (s *Solver) nonLosingMoves(current, mask uint64) uint64 {
+	possible := s.possible(mask)
+	opponentWins := s.winningCells(current^mask, mask)
+
+	// A threat of the opponent must be blocked, two at once can't be
+	forced := possible & opponentWins
+	if forced != 0 {
+		if forced&(forced-1) != 0 {
+			return 0
+		}
+		possible = forced
+	}
+
+	// Don't play right under a cell the opponent would win with
+	return possible &^ (opponentWins >> 1)
+}
+
+// possible returns the cells a disc can be dropped into
+func (s *Solver) possible(mask uint64) uint64 {
+	return (mask + s.bottom) & s.boardMask
+}
+
+// winningCells returns the empty cells that would complete four in a row for the discs
+func (s *Solver) winningCells(discs, mask uint64) uint64 {
+	h := uint(s.height)
+
+	// vertical
+	r := (discs << 1) & (discs << 2) & (discs << 3)
+
+	// horizontal and both diagonals
+	for _, shift := range []uint{h + 1, h, h + 2} {
+		p := (discs << shift) & (discs << (2 * shift))
+		r |= p & (discs << (3 * shift))
+		r |= p & (discs >> shift)
+		p = (discs >> shift) & (discs >> (2 * shift))
+		r |= p & (discs << shift)
+		r |= p & (discs >> (3 * shift))
+	}
+
+	return r & (s.boardMask ^ mask)
+}
+
+// topCell returns the bit of the top cell of a column
+func (s *Solver) topCell(col int) uint64 {
+	return 1 << uint(s.height-1+col*(s.height+1))
+}
+
+// bottomCell returns the bit of the bottom cell of a column
+func (s *Solver) bottomCell(col int) uint64 {
+	return 1 << uint(col*(s.height+1))
+}
+
+// columnMask returns the playable cells of a column
+func (s *Solver) columnMask(col int) uint64 {
+	return (1<<uint(s.height) - 1) << uint(col*(s.height+1))
+}
@@ -0,0 +1,159 @@
+package games
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSolve(t *testing.T) {
+	tests := []struct {
+		name  string
+		board string
+		value string
+		plies int
+	}{
+		{"win at once", "......./......./......./......./......./RRR.YYY R", SolveWin, 1},
+		{"open three wins", "......./......./......./......./......Y/..RR..Y R", SolveWin, 3},
+		{"double threat loses", "......./......./......./......./..RRR../..YYY.. R", SolveLoss, 2},
+		{"nearly full draws", ".YRRRY./RRYYYR./YYYRYYR/RRRYYYR/RYRRRYR/RYYRYRY Y", SolveDraw, 3},
+		{"filled up draw", "RR.R.RY/YYYR.YY/YRRYRYY/RRRYYRR/YRYRRRY/YYRRYRY Y", SolveDraw, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pos, token, err := ParseBoard(test.board, DefaultRules())
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := Solve(pos, token, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Value != test.value || result.Plies != test.plies {
+				t.Errorf("got %s in %d plies, want %s in %d", result.Value, result.Plies, test.value, test.plies)
+			}
+		})
+	}
+}
+
+func TestSolveColumns(t *testing.T) {
+	pos, token, err := ParseBoard("R....../Y....../R....../Y....../R....../YRRR.YY Y", DefaultRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := SolveColumns(pos, token, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0] != nil {
+		t.Errorf("full column 0 has a result %+v", *results[0])
+	}
+	// Yellow must block the row at column 4, every other move loses at once
+	for col := 1; col < 7; col++ {
+		result := results[col]
+		if result == nil {
+			t.Fatalf("column %d has no result", col)
+		}
+		lostAtOnce := result.Value == SolveLoss && result.Plies == 2
+		if lostAtOnce != (col != 4) {
+			t.Errorf("column %d: got %s in %d plies", col, result.Value, result.Plies)
+		}
+	}
+}
+
+func TestSolveErrors(t *testing.T) {
+	rules := DefaultRules()
+	rules.Variant = VariantPopOut
+	if _, err := Solve(NewPosition(rules), RedToken, time.Time{}); err != ErrSolveUnsupported {
+		t.Errorf("PopOut: got %v, want %v", err, ErrSolveUnsupported)
+	}
+	if _, err := Solve(NewPosition(DefaultRules()), RedToken, time.Now().Add(-time.Second)); err != ErrSolveTimeout {
+		t.Errorf("past deadline: got %v, want %v", err, ErrSolveTimeout)
+	}
+}
+
+// TestSolveEndgames checks the solver's scores against a plain search of
+// every line on random positions close to the end of the game
+func TestSolveEndgames(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for checked := 0; checked < 20; {
+		pos, token, ok := randomPosition(rng, 32)
+		if !ok {
+			continue
+		}
+		checked++
+		result, err := Solve(pos, token, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fullSearch(pos, token); result.Score != want {
+			t.Errorf("%s: got score %d, want %d", FormatBoard(pos, token), result.Score, want)
+		}
+	}
+}
+
+// randomPosition plays random drops up to the ply, ok is false if someone won on the way
+func randomPosition(rng *rand.Rand, ply int) (pos Position, token int, ok bool) {
+	pos, token = NewPosition(DefaultRules()), RedToken
+	for pos.MoveCount() < ply {
+		cols := []int{}
+		for col := 0; col < pos.Width(); col++ {
+			if pos.CanPlay(col) {
+				cols = append(cols, col)
+			}
+		}
+		pos.Play(cols[rng.Intn(len(cols))], token)
+		if pos.HasWon(token) {
+			return pos, token, false
+		}
+		token = otherToken(token)
+	}
+	return pos, token, true
+}
+
+// fullSearch scores the position like the solver, without any pruning
+func fullSearch(pos Position, token int) int {
+	cells, moves := pos.Width()*pos.Height(), pos.MoveCount()
+	if moves == cells {
+		return 0
+	}
+	best := -cells
+	for col := 0; col < pos.Width(); col++ {
+		if !pos.CanPlay(col) {
+			continue
+		}
+		next := pos
+		next.Play(col, token)
+		score := (cells + 1 - moves) / 2
+		if !next.HasWon(token) {
+			score = -fullSearch(next, otherToken(token))
+		}
+		best = max(best, score)
+	}
+	return best
+}
+
+func TestSolverMinPly(t *testing.T) {
+	early, token, err := ParseBoard("......./......./......./......./...Y.../..RR... Y", DefaultRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without the minimum ply the solver would take seconds here
+	bot := NewBotPlayer("", token)
+	bot.SetLevel(BotPerfect)
+	bot.StartTime = time.Now()
+	if action := bot.solvedAction(early, bot.Settings.TimeLimitMs); action != -1 || time.Since(bot.StartTime) > time.Second {
+		t.Errorf("ply %d was given to the solver", early.MoveCount())
+	}
+
+	late, token, err := ParseBoard(".YRRRY./RRYYYR./YYYRYYR/RRRYYYR/RYRRRYR/RYYRYRY Y", DefaultRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot = NewBotPlayer("", token)
+	bot.SetLevel(BotPerfect)
+	bot.StartTime = time.Now()
+	if action := bot.solvedAction(late, bot.Settings.TimeLimitMs); action == -1 {
+		t.Errorf("ply %d wasn't solved", late.MoveCount())
+	}
+}
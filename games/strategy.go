@@ -0,0 +1,384 @@
+package games
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Strategy is a way of choosing the bot's moves
+type Strategy interface {
+	// ChooseMove returns a legal move for the token, without a player ID.
+	// The position must not be over.
+	ChooseMove(board Position, token int, budget Budget) Move
+}
+
+// LeveledStrategy is a strategy whose difficulty can be changed
+type LeveledStrategy interface {
+	Strategy
+	SetLevel(level BotLevel) error
+}
+
+// SeededStrategy is a strategy whose random choices can be made repeatable
+type SeededStrategy interface {
+	Strategy
+	Seed(seed int64)
+}
+
+// ReleasingStrategy is a strategy that keeps memory from one move to the
+// next, such as a table or a tree, and can give it back once its game is over
+type ReleasingStrategy interface {
+	Strategy
+	Release()
+}
+
+// releaseBots lets the game's bots give back their memory. A bot that is
+// still thinking releases once it is done, the game doesn't wait for it.
+func (g *Game) releaseBots() {
+	bots := g.bots
+	go func() {
+		g.botMu.Lock()
+		defer g.botMu.Unlock()
+		for _, bot := range bots {
+			if releasing, ok := bot.(ReleasingStrategy); ok {
+				releasing.Release()
+			}
+		}
+	}()
+}
+
+// Budget limits how long a strategy may think about a move
+type Budget struct {
+	TimeLimit time.Duration // 0 leaves it to the strategy
+}
+
+// StrategyFactory creates a strategy for one bot seat
+type StrategyFactory func() Strategy
+
+// DefaultStrategy is played by the plain "bot" player ID
+const DefaultStrategy = "minimax"
+
+// strategy registry, name -> factory
+var (
+	strategies    = make(map[string]StrategyFactory)
+	strategyMutex = &sync.RWMutex{}
+)
+
+func init() {
+	RegisterStrategy("minimax", func() Strategy { return NewBotPlayer("", RedToken) })
+	RegisterStrategy("random", func() Strategy { return NewRandomStrategy() })
+	RegisterStrategy("greedy", func() Strategy { return GreedyStrategy{} })
+	RegisterStrategy("solver", func() Strategy { return NewSolverStrategy() })
+	RegisterStrategy("mcts", func() Strategy { return NewMCTSPlayer() })
+}
+
+// RegisterStrategy makes a strategy available as the player ID "bot:<name>"
+func RegisterStrategy(name string, factory StrategyFactory) {
+	strategyMutex.Lock()
+	defer strategyMutex.Unlock()
+	strategies[name] = factory
+}
+
+// NewStrategy creates the registered strategy with the name
+func NewStrategy(name string) (Strategy, error) {
+	strategyMutex.RLock()
+	factory, ok := strategies[name]
+	strategyMutex.RUnlock()
+	if !ok {
+		return nil, errors.New("unknown bot strategy " + name)
+	}
+	return factory(), nil
+}
+
+// StrategyNames lists the registered strategies
+func StrategyNames() []string {
+	strategyMutex.RLock()
+	defer strategyMutex.RUnlock()
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsBotID reports whether a player ID seats a bot: "bot" or "bot:<strategy>"
+func IsBotID(playerID string) bool {
+	return playerID == "bot" || strings.HasPrefix(playerID, "bot:")
+}
+
+// BotStrategyName returns the strategy a bot player ID asks for
+func BotStrategyName(playerID string) string {
+	if name := strings.TrimPrefix(playerID, "bot:"); name != playerID && name != "" {
+		return name
+	}
+	return DefaultStrategy
+}
+
+// ValidatePlayerID checks that a bot player ID names a registered strategy
+func ValidatePlayerID(playerID string) error {
+	if !IsBotID(playerID) {
+		return nil
+	}
+	_, err := NewStrategy(BotStrategyName(playerID))
+	return err
+}
+
+// BotFor returns the strategy playing the token, nil if a person plays it
+func (g *Game) BotFor(token int) Strategy {
+	if token != RedToken && token != YellowToken {
+		return nil
+	}
+	return g.bots[token]
+}
+
+// SetBot seats a bot for the token in place of the one its player ID asked
+// for, nil leaves the token to a person
+func (g *Game) SetBot(token int, bot Strategy) {
+	if token == RedToken || token == YellowToken {
+		g.bots[token] = bot
+	}
+}
+
+// HasBot reports whether a bot plays in the game
+func (g *Game) HasBot() bool {
+	return g.bots[RedToken] != nil || g.bots[YellowToken] != nil
+}
+
+// ErrStaleBotTurn is returned for a bot's move when the game changed while the bot was thinking
+var ErrStaleBotTurn = errors.New("the game changed while the bot was thinking")
+
+// BotTurn is the position a bot has to move in, taken from the game so the
+// bot can think without holding the game's lock, see StartBotTurn
+type BotTurn struct {
+	Token  int
+	Board  Position
+	Budget Budget
+	Search SearchInfo // How the bot found its move, filled in by Choose
+
+	bot     Strategy
+	mu      *sync.Mutex // The game's, a strategy thinks about one move at a time
+	version int         // The game's Version when the turn started
+}
+
+// PlayBotMove lets the bot whose turn it is move, while the caller holds the lock
+func (g *Game) PlayBotMove() error {
+	turn, err := g.StartBotTurn()
+	if err != nil {
+		return err
+	}
+	return g.FinishBotTurn(turn, turn.Choose())
+}
+
+// StartBotTurn returns the turn of the bot to move. A running clock caps the
+// time the bot takes, so it can't lose on time. The caller must hold the
+// game's lock, but may release it until FinishBotTurn.
+func (g *Game) StartBotTurn() (*BotTurn, error) {
+	token := g.CurrentTurn
+	bot := g.BotFor(token)
+	if bot == nil || g.Status != StatusActive {
+		return nil, errors.New("it is not a bot's turn")
+	}
+	if g.botTurn != nil && g.botTurn.version == g.version {
+		return nil, errors.New("the bot is already thinking")
+	}
+
+	budget := Budget{}
+	if g.Clock != nil {
+		budget.TimeLimit = g.Clock.Remaining(token, time.Now()) / 10
+		if budget.TimeLimit <= 0 {
+			return nil, errors.New("out of time")
+		}
+	}
+
+	g.botTurn = &BotTurn{Token: token, Board: g.Board, Budget: budget, bot: bot, mu: &g.botMu, version: g.version}
+	return g.botTurn, nil
+}
+
+// Choose asks the bot for its move. It doesn't touch the game, so it runs
+// without the game's lock.
+func (t *BotTurn) Choose() Move {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	start := time.Now()
+	move := t.bot.ChooseMove(t.Board, t.Token, t.Budget)
+
+	// Strategies that don't report their search still get their time measured
+	if informed, ok := t.bot.(InformedStrategy); ok {
+		t.Search = informed.LastSearch()
+	} else {
+		t.Search = SearchInfo{ElapsedMs: time.Since(start).Milliseconds(), PV: []Move{move}}
+	}
+	return move
+}
+
+// FinishBotTurn plays the bot's move, or returns ErrStaleBotTurn if a move,
+// takeback or reset came first. The caller must hold the game's lock. The
+// move is played by token rather than player ID, two bots may share an ID
+// like "bot:minimax".
+func (g *Game) FinishBotTurn(t *BotTurn, move Move) error {
+	if g.botTurn == t {
+		g.botTurn = nil
+	}
+	if t.version != g.version || g.CurrentTurn != t.Token || g.Status != StatusActive {
+		return ErrStaleBotTurn
+	}
+	playerID := g.playerID(t.Token)
+	var err error
+	if move.Kind == MovePop {
+		err = g.pop(playerID, t.Token, move.Column)
+	} else {
+		err = g.drop(playerID, t.Token, move.Column)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Keep how the move was found with it, for the game's debug view and the metrics
+	search := t.Search
+	if IsBotID(playerID) {
+		search.Strategy = BotStrategyName(playerID)
+	}
+	g.Moves[len(g.Moves)-1].Search = &search
+	recordBotSearch(search)
+	return nil
+}
+
+// seatBots creates the strategies of the seats with a bot player ID.
+// An unknown strategy falls back to the default one, see ValidatePlayerID.
+func (g *Game) seatBots() {
+	for _, token := range []int{RedToken, YellowToken} {
+		playerID := g.playerID(token)
+		g.bots[token] = nil
+		if !IsBotID(playerID) {
+			continue
+		}
+		bot, err := NewStrategy(BotStrategyName(playerID))
+		if err != nil {
+			bot, _ = NewStrategy(DefaultStrategy)
+		}
+		g.bots[token] = bot
+		if _, ok := bot.(LeveledStrategy); ok && g.BotLevel == "" {
+			g.BotLevel = DefaultBotLevel
+		}
+	}
+}
+
+// RandomStrategy plays a random legal move
+type RandomStrategy struct {
+	rng *rand.Rand
+}
+
+// NewRandomStrategy creates a random strategy
+func NewRandomStrategy() *RandomStrategy {
+	return &RandomStrategy{rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Seed makes the moves repeatable
+func (r *RandomStrategy) Seed(seed int64) {
+	r.rng = rand.New(rand.NewSource(seed))
+}
+
+// ChooseMove picks any legal move
+func (r *RandomStrategy) ChooseMove(board Position, token int, budget Budget) Move {
+	moves := legalMoves(board, token)
+	if len(moves) == 0 {
+		return Move{Column: -1}
+	}
+	return moves[r.rng.Intn(len(moves))]
+}
+
+// GreedyStrategy looks one move ahead: it wins if it can, blocks the
+// opponent's immediate win and otherwise plays the move that evaluates best
+type GreedyStrategy struct{}
+
+// ChooseMove picks the best move one ply deep
+func (GreedyStrategy) ChooseMove(board Position, token int, budget Budget) Move {
+	bot := NewBotPlayer("", token)
+	best, bestScore := Move{Column: -1}, 0
+	for _, move := range legalMoves(board, token) {
+		boardCopy := board
+		if move.Kind == MovePop {
+			boardCopy.Pop(move.Column)
+		} else {
+			boardCopy.Play(move.Column, token)
+		}
+
+		var score int
+		switch moveWinner(boardCopy, token) {
+		case token:
+			return move
+		case otherToken(token):
+			score = -WinScore
+		default:
+			score = bot.evaluateBoard(boardCopy)
+			if canWinNow(boardCopy, otherToken(token)) {
+				score = -WinScore / 2
+			}
+		}
+		if best.Column == -1 || score > bestScore {
+			best, bestScore = move, score
+		}
+	}
+	return best
+}
+
+// SolverStrategy plays perfectly with the solver. Positions before
+// SolverMinPly, positions the solver can't finish in time and rules it
+// doesn't support are played by the minimax bot.
+// Unlike the perfect bot level it keeps playing perfectly whatever the game's level.
+type SolverStrategy struct {
+	Bot *BotPlayer // Plays at the perfect level, its search is the fallback
+}
+
+// NewSolverStrategy creates a solver strategy
+func NewSolverStrategy() *SolverStrategy {
+	bot := NewBotPlayer("", RedToken)
+	bot.SetLevel(BotPerfect)
+	return &SolverStrategy{Bot: bot}
+}
+
+// ChooseMove picks the best column according to the solver
+func (s *SolverStrategy) ChooseMove(board Position, token int, budget Budget) Move {
+	return s.Bot.ChooseMove(board, token, budget)
+}
+
+// LastSearch tells how the solver, or the minimax bot, found the last move
+func (s *SolverStrategy) LastSearch() SearchInfo {
+	return s.Bot.LastSearch()
+}
+
+// canWinNow tells whether the token has a move that wins at once
+func canWinNow(board Position, token int) bool {
+	for _, move := range legalMoves(board, token) {
+		boardCopy := board
+		if move.Kind == MovePop {
+			boardCopy.Pop(move.Column)
+		} else {
+			boardCopy.Play(move.Column, token)
+		}
+		if moveWinner(boardCopy, token) == token {
+			return true
+		}
+	}
+	return false
+}
+
+// legalMoves returns every move the token can play, drops first
+func legalMoves(board Position, token int) []Move {
+	moves := []Move{}
+	for col := 0; col < board.Width(); col++ {
+		if board.CanPlay(col) {
+			moves = append(moves, Move{Column: col, Kind: MoveDrop})
+		}
+	}
+	for col := 0; col < board.Width(); col++ {
+		if board.CanPop(col, token) {
+			moves = append(moves, Move{Column: col, Kind: MovePop})
+		}
+	}
+	return moves
+}
@@ -0,0 +1,40 @@
+package games
+
+import "testing"
+
+// TestBotTurnAfterTakeBack takes a move back while the bot thinks about it
+// and plays another at the same ply: the bot must start on the new move and
+// the old turn's move must be thrown away
+func TestBotTurnAfterTakeBack(t *testing.T) {
+	game := NewGame(SinglePlayer, "alice", "bot", DefaultRules())
+	game.SetBot(YellowToken, GreedyStrategy{})
+	game.Start()
+	if err := game.MakeMove("alice", 3); err != nil {
+		t.Fatal(err)
+	}
+	old, err := game.StartBotTurn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := game.TakeBack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := game.MakeMove("alice", 2); err != nil {
+		t.Fatal(err)
+	}
+	turn, err := game.StartBotTurn()
+	if err != nil {
+		t.Fatalf("the bot didn't start on the new move: %v", err)
+	}
+
+	if err := game.FinishBotTurn(old, old.Choose()); err != ErrStaleBotTurn {
+		t.Errorf("the old turn finished with %v, want %v", err, ErrStaleBotTurn)
+	}
+	if err := game.FinishBotTurn(turn, turn.Choose()); err != nil {
+		t.Fatal(err)
+	}
+	if len(game.Moves) != 2 || game.CurrentTurn != RedToken {
+		t.Errorf("got %d moves with %d to move, want the bot's reply to column 2", len(game.Moves), game.CurrentTurn)
+	}
+}
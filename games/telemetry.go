@@ -0,0 +1,100 @@
+package games
+
+import (
+	"expvar"
+	"sync"
+)
+
+// How a bot found its move, see SearchInfo
+const (
+	SourceSearch   = "search"   // Minimax search
+	SourceBook     = "book"     // Opening book
+	SourceSolver   = "solver"   // Perfect play from the solver
+	SourceMistake  = "mistake"  // Random move of a weak level
+	SourcePlayouts = "playouts" // Monte Carlo tree search
+)
+
+// SearchInfo tells how a bot found a move, to find out why a move was slow or weak
+type SearchInfo struct {
+	Strategy  string  `json:"strategy,omitempty"` // Strategy of the bot's player ID
+	Source    string  `json:"source,omitempty"`
+	Profile   string  `json:"profile,omitempty"` // Evaluation profile of the minimax bot
+	Depth     int     `json:"depth"`             // Deepest finished iteration, or deepest line of the MCTS tree
+	Nodes     int     `json:"nodes"`             // Positions searched, or playouts for MCTS
+	NPS       int     `json:"nps"`               // Nodes per second
+	ElapsedMs int64   `json:"elapsedMs"`
+	TTHitRate float64 `json:"ttHitRate"`         // Share of table lookups that found the position
+	Score     int     `json:"score"`             // Positive is good for the bot, see WinScore
+	WinRate   float64 `json:"winRate,omitempty"` // Playout result of the move for MCTS, 1 is a sure win
+	PV        []Move  `json:"pv,omitempty"`      // Expected line of play, starting with the move
+}
+
+// InformedStrategy is a strategy that tells how it found its last move
+type InformedStrategy interface {
+	Strategy
+	LastSearch() SearchInfo
+}
+
+// MoveSearch is the search info of one bot move of a game
+type MoveSearch struct {
+	Ply      int    `json:"ply"`
+	PlayerID string `json:"playerId"`
+	Move     Move   `json:"move"`
+	SearchInfo
+}
+
+// BotSearches returns the search info of every bot move of the game, in order
+func (g *Game) BotSearches() []MoveSearch {
+	searches := []MoveSearch{}
+	for _, record := range g.Moves {
+		if record.Search == nil {
+			continue
+		}
+		searches = append(searches, MoveSearch{
+			Ply:        record.Ply,
+			PlayerID:   record.PlayerID,
+			Move:       Move{Column: record.Column, Kind: record.Kind},
+			SearchInfo: *record.Search,
+		})
+	}
+	return searches
+}
+
+// Server metrics of the bots' moves, by strategy, published at /debug/vars
+var (
+	botMoves   = expvar.NewMap("botMoves")   // Moves played
+	botNodes   = expvar.NewMap("botNodes")   // Nodes searched
+	botDepth   = expvar.NewMap("botDepth")   // Sum of the depths reached, divide by botMoves for the average
+	botThinkMs = expvar.NewMap("botThinkMs") // Time spent thinking
+	botSources = expvar.NewMap("botSources") // Moves by how they were found
+
+	lastBotSearch      SearchInfo
+	lastBotSearchMutex = &sync.Mutex{}
+)
+
+func init() {
+	expvar.Publish("botLastSearch", expvar.Func(func() any {
+		lastBotSearchMutex.Lock()
+		defer lastBotSearchMutex.Unlock()
+		return lastBotSearch
+	}))
+}
+
+// recordBotSearch adds a bot's move to the server metrics
+func recordBotSearch(info SearchInfo) {
+	strategy := info.Strategy
+	if strategy == "" {
+		strategy = "other"
+	}
+	botMoves.Add(strategy, 1)
+	botNodes.Add(strategy, int64(info.Nodes))
+	botDepth.Add(strategy, int64(info.Depth))
+	botThinkMs.Add(strategy, info.ElapsedMs)
+	if info.Source != "" {
+		botSources.Add(info.Source, 1)
+	}
+
+	lastBotSearchMutex.Lock()
+	lastBotSearch = info
+	lastBotSearchMutex.Unlock()
+}
@@ -0,0 +1,136 @@
+package games
+
+import "sync/atomic"
+
+// zobristKeys holds a random key for every bitboard cell and token, the hash
+// of a position is the xor of the keys of all its discs
+var zobristKeys [MaxBoardCells][2]uint64
+
+// zobristYellow is mixed into table keys when yellow is to move
+var zobristYellow uint64
+
+func init() {
+	// A fixed seed keeps hashes the same from run to run
+	seed := uint64(0x9E3779B97F4A7C15)
+	next := func() uint64 {
+		// splitmix64
+		seed += 0x9E3779B97F4A7C15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+		z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+		return z ^ (z >> 31)
+	}
+	for i := range zobristKeys {
+		zobristKeys[i][0] = next()
+		zobristKeys[i][1] = next()
+	}
+	zobristYellow = next()
+}
+
+// Bound says how a stored score relates to the real score of a position
+type Bound uint8
+
+const (
+	BoundNone  Bound = iota // Empty slot
+	BoundExact              // The score is exact
+	BoundLower              // The real score is at least the score, the search failed high
+	BoundUpper              // The real score is at most the score, the search failed low
+)
+
+// DefaultTableBits sizes a bot's table at 2^17 entries of 16 bytes, 2 MB
+const DefaultTableBits = 17
+
+// TTEntry is one slot of a transposition table
+type TTEntry struct {
+	Key        uint64
+	Score      int32
+	Depth      int8 // Plies searched below the position
+	Bound      Bound
+	Move       int8  // Best action found, -1 if none
+	generation uint8 // Search the entry was stored in
+}
+
+// TransTable is a fixed size transposition table. Each key has a single slot,
+// a new entry replaces the old one if the old one is from an earlier search
+// or wasn't searched deeper.
+//
+// The workers of a parallel search share the table without a lock. A slot
+// holds the entry packed into one word and the key xored with that word, a
+// slot torn by two workers writing at once doesn't match any key.
+type TransTable struct {
+	slots      []ttSlot
+	mask       uint64
+	generation uint8 // Only changed between searches
+}
+
+// ttSlot is one packed entry of a table, see TransTable
+type ttSlot struct {
+	check atomic.Uint64 // Key xor data
+	data  atomic.Uint64
+}
+
+// NewTransTable creates a table with 2^bits entries
+func NewTransTable(bits int) *TransTable {
+	return &TransTable{
+		slots: make([]ttSlot, 1<<uint(bits)),
+		mask:  1<<uint(bits) - 1,
+	}
+}
+
+// TableKey returns the table key of a position with the token to move
+func TableKey(board Position, token int) uint64 {
+	if token == YellowToken {
+		return board.Hash() ^ zobristYellow
+	}
+	return board.Hash()
+}
+
+// NewSearch ages the entries of earlier searches so they are replaced first
+func (tt *TransTable) NewSearch() {
+	tt.generation++
+}
+
+// Clear empties the table, no search may be using it
+func (tt *TransTable) Clear() {
+	clear(tt.slots)
+	tt.generation = 0
+}
+
+// Probe returns the entry stored for the key
+func (tt *TransTable) Probe(key uint64) (TTEntry, bool) {
+	entry := tt.load(&tt.slots[key&tt.mask])
+	if entry.Bound == BoundNone || entry.Key != key {
+		return TTEntry{}, false
+	}
+	return entry, true
+}
+
+// Store saves a search result for the key
+func (tt *TransTable) Store(key uint64, depth int, score int, bound Bound, move int) {
+	slot := &tt.slots[key&tt.mask]
+	old := tt.load(slot)
+	if old.Bound != BoundNone && old.Key != key && old.generation == tt.generation && int(old.Depth) > depth {
+		return
+	}
+
+	data := uint64(uint32(int32(score))) |
+		uint64(uint8(int8(depth)))<<32 |
+		uint64(bound)<<40 |
+		uint64(uint8(int8(move)))<<48 |
+		uint64(tt.generation)<<56
+	slot.data.Store(data)
+	slot.check.Store(key ^ data)
+}
+
+// load unpacks a slot. The key of a torn slot is wrong, so it never matches.
+func (tt *TransTable) load(slot *ttSlot) TTEntry {
+	data := slot.data.Load()
+	return TTEntry{
+		Key:        slot.check.Load() ^ data,
+		Score:      int32(uint32(data)),
+		Depth:      int8(uint8(data >> 32)),
+		Bound:      Bound(uint8(data >> 40)),
+		Move:       int8(uint8(data >> 48)),
+		generation: uint8(data >> 56),
+	}
+}
@@ -0,0 +1,79 @@
+package games
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tableSearch returns a search of the position's player with an empty table,
+// ready for minimax to be called on it
+func tableSearch(t *testing.T, snapshot string) (*search, Position) {
+	t.Helper()
+	pos, token, err := ParseBoard(snapshot, DefaultRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot := NewBotPlayer("", token)
+	bot.startSearch()
+	return &search{bot: bot, start: time.Now(), limitMs: 60000, stop: &atomic.Bool{}, best: -1}, pos
+}
+
+// TestTableBoundCutoff stores a bound for the position and checks that it
+// ends the search only when it is outside the window and deep enough
+func TestTableBoundCutoff(t *testing.T) {
+	tests := []struct {
+		name        string
+		depth       int // Depth of the stored entry, the search is 4 deep
+		score       int
+		bound       Bound
+		alpha, beta int
+		cut         bool
+	}{
+		{"lower bound above beta", 6, 500, BoundLower, -1000, 400, true},
+		{"lower bound below beta", 6, 300, BoundLower, -1000, 400, false},
+		{"upper bound below alpha", 6, -500, BoundUpper, -400, 1000, true},
+		{"upper bound above alpha", 6, -300, BoundUpper, -400, 1000, false},
+		{"exact score", 6, 123, BoundExact, -1000, 1000, true},
+		{"too shallow", 2, 500, BoundLower, -1000, 400, false},
+	}
+	for _, test := range tests {
+		s, pos := tableSearch(t, "......./......./......./...Y.../..RR.../..YRY.. R")
+		s.bot.TransTable.Store(TableKey(pos, s.bot.PlayerToken), test.depth, test.score, test.bound, 2)
+
+		var line []int
+		score := s.minimax(pos, 4, 1, test.alpha, test.beta, true, false, &line)
+		if cut := s.nodes == 1; cut != test.cut {
+			t.Errorf("%s: searched %d nodes, cut off %v, want %v", test.name, s.nodes, cut, test.cut)
+		}
+		if test.cut && score != test.score {
+			t.Errorf("%s: got score %d, want the stored %d", test.name, score, test.score)
+		}
+	}
+}
+
+// TestTableMateScore checks that a win stored at one ply is read back as a
+// win the same number of moves away at another ply
+func TestTableMateScore(t *testing.T) {
+	tests := []struct {
+		score int // Found 3 plies from the root
+		want  int // Read back 7 plies from the root
+	}{
+		{winScore(5), winScore(9)},
+		{-winScore(6), -winScore(10)},
+		{250, 250},
+	}
+	for _, test := range tests {
+		stored := toTableScore(test.score, 3)
+		if got := fromTableScore(stored, 7); got != test.want {
+			t.Errorf("score %d stored at ply 3 came back as %d at ply 7, want %d", test.score, got, test.want)
+		}
+
+		s, pos := tableSearch(t, "......./......./......./...Y.../..RR.../..YRY.. R")
+		s.bot.TransTable.Store(TableKey(pos, s.bot.PlayerToken), 10, stored, BoundExact, 2)
+		var line []int
+		if got := s.minimax(pos, 4, 7, -WinScore, WinScore, true, false, &line); got != test.want {
+			t.Errorf("score %d stored at ply 3 was searched as %d at ply 7, want %d", test.score, got, test.want)
+		}
+	}
+}
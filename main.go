@@ -2,12 +2,15 @@
 package main
 
 import (
+	"expvar"
+	"flag"
 	"log"
 	"net/http"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"connect4/api"
 	"connect4/db"
+	"connect4/games"
 )
 
 var upgrader = websocket.Upgrader{
@@ -19,6 +22,31 @@ var upgrader = websocket.Upgrader{
 }
 
 func main() {
+	bookPath := flag.String("book", "", "Opening book for the bot, see cmd/bookgen")
+	bookMargin := flag.Int("book-margin", 0, "Let the bot play book moves scoring up to this much below the best, for variety")
+	botWorkers := flag.Int("bot-workers", games.DefaultBotWorkers, "Goroutines each bot move is searched with")
+	profileDir := flag.String("profiles", "profiles", "Directory of evaluation profiles for the bot, one JSON file each")
+	flag.Parse()
+	games.DefaultBotWorkers = *botWorkers
+	
+	// A broken profile stops the server rather than turning up in a game
+	profiles, err := games.LoadProfiles(*profileDir)
+	if err != nil {
+		log.Fatalf("Failed to load evaluation profiles: %v", err)
+	}
+	log.Printf("Loaded %d evaluation profiles from %s", len(profiles), *profileDir)
+	
+	// Load the opening book before any bot moves
+	if *bookPath != "" {
+		book, err := games.LoadBook(*bookPath)
+		if err != nil {
+			log.Fatalf("Failed to load opening book: %v", err)
+		}
+		book.Margin = *bookMargin
+		games.UseBook(book)
+		log.Printf("Loaded opening book with %d positions", len(book.Positions))
+	}
+	
 	// Initialize database connection
 	if err := db.Initialize(); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
@@ -33,14 +61,31 @@ func main() {
 	router.HandleFunc("/api/players/{id}", api.GetPlayer).Methods("GET")
 	router.HandleFunc("/api/leaderboard", api.GetLeaderboard).Methods("GET")
 	
+	router.HandleFunc("/api/bots", api.GetBotStrategies).Methods("GET")
+	router.HandleFunc("/api/profiles", api.GetProfiles).Methods("GET")
 	router.HandleFunc("/api/games", api.CreateGame).Methods("POST")
 	router.HandleFunc("/api/games", api.GetGames).Methods("GET")
 	router.HandleFunc("/api/games/{id}", api.GetGame).Methods("GET")
 	router.HandleFunc("/api/games/{id}", api.GetGame).Methods("Put")
+	router.HandleFunc("/api/games/{id}/moves", api.GetGameMoves).Methods("GET")
+	router.HandleFunc("/api/games/{id}/analysis", api.GetGameAnalysis).Methods("GET")
 	router.HandleFunc("/api/games/{id}/move", api.MakeMove).Methods("POST")
 	router.HandleFunc("/api/games/{id}/reset", api.ResetGame).Methods("POST")
+	router.HandleFunc("/api/games/{id}/undo", api.UndoMove).Methods("POST")
+	router.HandleFunc("/api/games/{id}/resign", api.Resign).Methods("POST")
+	router.HandleFunc("/api/games/{id}/draw/offer", api.OfferDraw).Methods("POST")
+	router.HandleFunc("/api/games/{id}/draw/accept", api.AcceptDraw).Methods("POST")
+	router.HandleFunc("/api/games/{id}/draw/decline", api.DeclineDraw).Methods("POST")
+	router.HandleFunc("/api/exhibitions", api.CreateExhibition).Methods("POST")
+	router.HandleFunc("/api/games/{id}/exhibition", api.GetExhibition).Methods("GET")
+	router.HandleFunc("/api/games/{id}/exhibition/start", api.StartExhibition).Methods("POST")
+	router.HandleFunc("/api/games/{id}/exhibition/pause", api.PauseExhibition).Methods("POST")
+	router.HandleFunc("/api/games/{id}/exhibition/stop", api.StopExhibition).Methods("POST")
 	router.HandleFunc("/api/matchmaking", api.MatchMaking).Methods("POST")
 
+	// Server metrics, including the bots' search statistics
+	router.Handle("/debug/vars", expvar.Handler())
+
 	// WebSocket endpoint for real-time gameplay
 	router.HandleFunc("/ws/game/{id}", handleGameWebSocket)
 	router.HandleFunc("/ws/", handleGlobalConnection);